@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"log"
 
+	redis "github.com/redis/go-redis/v9"
+
 	"github.com/okulik/img-resize/internal/cache"
 	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/jobs"
+	"github.com/okulik/img-resize/internal/scheduler"
 	"github.com/okulik/img-resize/internal/service"
 	"github.com/okulik/img-resize/internal/settings"
 )
@@ -15,16 +20,65 @@ func main() {
 		log.Fatal(err)
 	}
 
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", settings.Service.RedisHost, settings.Service.RedisPort),
+		Password: "",
+		DB:       0,
+	})
+
+	// The index of cached images by source URL is kept in Redis, along with
+	// the cache, so that it covers the images cached by every instance
+	sourceIndex := cache.NewRedisSourceIndex(redisClient, settings)
+
 	//cache, err := cache.NewLRUImageCache(settings.Service.ImageCacheSize)
-	cache, err := cache.NewRedisImageCache(nil, settings)
+	cache, err := cache.NewRedisImageCache(redisClient, settings)
 	if err != nil {
 		log.Panicf("Faild to create image cache: %v", err)
 	}
 
-	resizer := image.NewResizer(settings, cache)
+	// Jobs left unprocessed at shutdown are saved to disk; the Redis queue
+	// keeps its own
+	backends := image.ResizerBackends{
+		Snapshots:   image.NewFileJobSnapshot(settings.Service.JobSnapshotPath),
+		SourceIndex: sourceIndex,
+	}
+	var schedules scheduler.ScheduleStoreAdapter
+	switch settings.Service.QueueBackend {
+	case "memory":
+		backends.Queue = image.NewMemoryJobQueue(settings)
+		backends.Jobs = jobs.NewMemoryJobStore(settings.Service.JobRetention)
+		backends.DeadLetters = image.NewMemoryDeadLetterStore()
+		schedules = scheduler.NewMemoryScheduleStore()
+	case "redis":
+		backends.Queue = image.NewRedisJobQueue(redisClient, settings)
+		backends.Jobs = jobs.NewRedisJobStore(redisClient, settings.Service.JobRetention)
+		backends.DeadLetters = image.NewRedisDeadLetterStore(redisClient)
+		schedules = scheduler.NewRedisScheduleStore(redisClient)
+	default:
+		log.Fatalf("unknown queue backend %s", settings.Service.QueueBackend)
+	}
+
+	// Images in progress are tracked where the queue is, unless told otherwise
+	progressBackend := settings.Service.ProgressBackend
+	if progressBackend == "" {
+		progressBackend = settings.Service.QueueBackend
+	}
+	switch progressBackend {
+	case "memory":
+		backends.ResizingProgress = image.NewLocalResizingProgress(settings)
+	case "redis":
+		backends.ResizingProgress = image.NewRedisResizingProgress(redisClient, settings)
+	default:
+		log.Fatalf("unknown progress backend %s", progressBackend)
+	}
+
+	resizer := image.NewResizerWithBackends(settings, cache, backends)
 	resizer.Start()
 
-	svc := service.NewService(settings, cache, resizer)
+	scheduler := scheduler.NewScheduler(settings, schedules, resizer)
+	scheduler.Start()
+
+	svc := service.NewService(settings, cache, resizer, scheduler)
 	if err := svc.Start(); err != nil {
 		log.Fatal(err)
 	}
@@ -14,6 +14,7 @@ require (
 	github.com/go-redis/redismock/v9 v9.2.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/redis/go-redis/v9 v9.21.0
+	golang.org/x/image v0.25.0
 )
 
 require (
@@ -6,4 +6,6 @@ type ImageCacheAdapter interface {
 	Get(ctx context.Context, key string) ([]byte, bool)
 	Contains(ctx context.Context, key string) bool
 	Add(ctx context.Context, key string, value any) bool
+	// Removes the image from the cache. Returns false if it wasn't cached.
+	Delete(ctx context.Context, key string) (bool, error)
 }
@@ -39,3 +39,7 @@ func (cache *LRUImageCache) Contains(_ context.Context, key string) bool {
 func (cache *LRUImageCache) Add(_ context.Context, key string, value any) bool {
 	return cache.Cache.Add(key, value)
 }
+
+func (cache *LRUImageCache) Delete(_ context.Context, key string) (bool, error) {
+	return cache.Cache.Remove(key), nil
+}
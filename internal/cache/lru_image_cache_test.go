@@ -59,6 +59,22 @@ func TestLRUImageCacheContains(t *testing.T) {
 	}
 }
 
+func TestLRUImageCacheDelete(t *testing.T) {
+	cache, err := cache.NewLRUImageCache(100)
+	if err != nil {
+		t.Error("error allocating LRUImageCache")
+	}
+	cache.Add(context.Background(), "foo", []byte("bar"))
+
+	if deleted, err := cache.Delete(context.Background(), "foo"); !deleted || err != nil || cache.Contains(context.Background(), "foo") {
+		t.Error("delete method not removing the item")
+	}
+
+	if deleted, _ := cache.Delete(context.Background(), "foo"); deleted {
+		t.Error("delete method expected to return false for nonexistent key")
+	}
+}
+
 type MockCache lru.Cache
 
 func NewMockCache(size int) (*lru.Cache, error) {
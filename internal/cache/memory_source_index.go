@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// How many source URLs are indexed, unless set.
+const defaultSourceIndexSize = 1024
+
+// MemorySourceIndex keeps the index in memory. It holds up to as many source
+// URLs as the image cache holds images, dropping the least recently cached
+// ones first.
+type MemorySourceIndex struct {
+	sources *lru.Cache // of sets of image IDs, by source URL
+	mu      sync.Mutex
+}
+
+func NewMemorySourceIndex(size int) SourceIndexAdapter {
+	if size <= 0 {
+		size = defaultSourceIndexSize
+	}
+	sources, _ := lru.New(size)
+
+	return &MemorySourceIndex{sources: sources}
+}
+
+func (index *MemorySourceIndex) Add(_ context.Context, sourceURL string, imageID string) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	ids, ok := index.sources.Get(sourceURL)
+	if !ok {
+		ids = make(map[string]struct{})
+		index.sources.Add(sourceURL, ids)
+	}
+	ids.(map[string]struct{})[imageID] = struct{}{}
+
+	return nil
+}
+
+func (index *MemorySourceIndex) Get(_ context.Context, sourceURL string) ([]string, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	ids, ok := index.sources.Peek(sourceURL)
+	if !ok {
+		return nil, nil
+	}
+
+	imageIDs := make([]string, 0, len(ids.(map[string]struct{})))
+	for id := range ids.(map[string]struct{}) {
+		imageIDs = append(imageIDs, id)
+	}
+	slices.Sort(imageIDs)
+
+	return imageIDs, nil
+}
+
+func (index *MemorySourceIndex) Delete(_ context.Context, sourceURL string) error {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.sources.Remove(sourceURL)
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package cache_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/cache"
+)
+
+func TestMemorySourceIndex(t *testing.T) {
+	ctx := context.Background()
+	index := cache.NewMemorySourceIndex(2)
+
+	_ = index.Add(ctx, "https://example.com/a.jpg", "b")
+	_ = index.Add(ctx, "https://example.com/a.jpg", "a")
+	_ = index.Add(ctx, "https://example.com/a.jpg", "a")
+
+	ids, err := index.Get(ctx, "https://example.com/a.jpg")
+	if err != nil || !slices.Equal(ids, []string{"a", "b"}) {
+		t.Errorf("unexpected image IDs: %v, %v", ids, err)
+	}
+
+	// the least recently indexed sources are dropped first
+	_ = index.Add(ctx, "https://example.com/b.jpg", "c")
+	_ = index.Add(ctx, "https://example.com/a.jpg", "d")
+	_ = index.Add(ctx, "https://example.com/c.jpg", "e")
+
+	if ids, _ := index.Get(ctx, "https://example.com/b.jpg"); len(ids) != 0 {
+		t.Errorf("expected source to be dropped, got: %v", ids)
+	}
+
+	_ = index.Delete(ctx, "https://example.com/a.jpg")
+	if ids, _ := index.Get(ctx, "https://example.com/a.jpg"); len(ids) != 0 {
+		t.Errorf("expected source to be deleted, got: %v", ids)
+	}
+}
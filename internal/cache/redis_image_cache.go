@@ -55,3 +55,12 @@ func (cache *RedisImageCache) Add(ctx context.Context, key string, value any) bo
 
 	return true
 }
+
+func (cache *RedisImageCache) Delete(ctx context.Context, key string) (bool, error) {
+	deleted, err := cache.Client.Del(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return deleted > 0, nil
+}
@@ -2,6 +2,7 @@ package cache_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -79,6 +80,30 @@ func TestRedisImageCacheContains(t *testing.T) {
 	}
 }
 
+func TestRedisImageCacheDelete(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectDel("foo").SetVal(1)
+	mock.ExpectDel("baz").SetVal(0)
+	mock.ExpectDel("qux").SetErr(errors.New("connection refused"))
+
+	cache, err := cache.NewRedisImageCache(db, buildSettings(0))
+	if err != nil {
+		t.Error("error allocating RedisImageCache")
+	}
+
+	if deleted, err := cache.Delete(context.Background(), "foo"); !deleted || err != nil {
+		t.Error("delete method returning unexpected value")
+	}
+
+	if deleted, _ := cache.Delete(context.Background(), "baz"); deleted {
+		t.Error("delete method expected to return false for nonexistent key")
+	}
+
+	if _, err := cache.Delete(context.Background(), "qux"); err == nil {
+		t.Error("delete method expected to return the error of a failed delete")
+	}
+}
+
 func buildSettings(ttl time.Duration) *settings.Settings {
 	return &settings.Settings{
 		Service: &settings.ServiceSettings{
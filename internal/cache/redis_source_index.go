@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+const sourceKeyPrefix = "img-resize:source:"
+
+// RedisSourceIndex keeps the image IDs of each source URL in a Redis set,
+// which expires along with the last image added to it.
+type RedisSourceIndex struct {
+	client   *redis.Client
+	settings *settings.Settings
+}
+
+func NewRedisSourceIndex(client *redis.Client, settings *settings.Settings) SourceIndexAdapter {
+	return &RedisSourceIndex{
+		client:   client,
+		settings: settings,
+	}
+}
+
+func (index *RedisSourceIndex) Add(ctx context.Context, sourceURL string, imageID string) error {
+	key := sourceKey(sourceURL)
+	_, err := index.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, key, imageID)
+		if ttl := index.settings.Service.ImageCacheTTL; ttl > 0 {
+			pipe.Expire(ctx, key, ttl)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (index *RedisSourceIndex) Get(ctx context.Context, sourceURL string) ([]string, error) {
+	imageIDs, err := index.client.SMembers(ctx, sourceKey(sourceURL)).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(imageIDs)
+
+	return imageIDs, nil
+}
+
+func (index *RedisSourceIndex) Delete(ctx context.Context, sourceURL string) error {
+	return index.client.Del(ctx, sourceKey(sourceURL)).Err()
+}
+
+// Source URLs are hashed, to keep the keys short.
+func sourceKey(sourceURL string) string {
+	sum := sha256.Sum256([]byte(sourceURL))
+	return sourceKeyPrefix + hex.EncodeToString(sum[:])
+}
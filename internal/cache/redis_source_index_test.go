@@ -0,0 +1,54 @@
+package cache_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+
+	"github.com/okulik/img-resize/internal/cache"
+)
+
+// img-resize:source: followed by the SHA-256 of https://example.com/a.jpg
+const sourceKey = "img-resize:source:276a1ac00ba4f0ea47eeeafca24284f41bc78dc593af1f048615aceba44ab9d9"
+
+func TestRedisSourceIndexAdd(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectTxPipeline()
+	mock.ExpectSAdd(sourceKey, "foo").SetVal(1)
+	mock.ExpectExpire(sourceKey, time.Hour).SetVal(true)
+	mock.ExpectTxPipelineExec()
+
+	index := cache.NewRedisSourceIndex(db, buildSettings(time.Hour))
+
+	if err := index.Add(context.Background(), "https://example.com/a.jpg", "foo"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisSourceIndexGetAndDelete(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectSMembers(sourceKey).SetVal([]string{"foo", "bar"})
+	mock.ExpectDel(sourceKey).SetVal(1)
+
+	index := cache.NewRedisSourceIndex(db, buildSettings(0))
+
+	ids, err := index.Get(context.Background(), "https://example.com/a.jpg")
+	if err != nil || !slices.Equal(ids, []string{"bar", "foo"}) {
+		t.Errorf("unexpected image IDs: %v, %v", ids, err)
+	}
+
+	if err := index.Delete(context.Background(), "https://example.com/a.jpg"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
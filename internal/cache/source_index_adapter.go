@@ -0,0 +1,12 @@
+package cache
+
+import "context"
+
+// SourceIndexAdapter keeps, for each source image URL, the IDs of the cached
+// images derived from it, so that they can be purged together. IDs of images
+// evicted from the cache may linger in the index; deleting them is harmless.
+type SourceIndexAdapter interface {
+	Add(ctx context.Context, sourceURL string, imageID string) error
+	Get(ctx context.Context, sourceURL string) ([]string, error)
+	Delete(ctx context.Context, sourceURL string) error
+}
@@ -0,0 +1,159 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+// Returned when a URL, or the address its host resolves to, is not allowed
+// to be fetched.
+var ErrForbiddenOrigin = errors.New("forbidden origin")
+
+var (
+	defaultAllowedSchemes = []string{"http", "https"}
+
+	// Ranges not covered by the net.IP predicates used in isForbiddenIP.
+	forbiddenNetworks = []*net.IPNet{
+		mustParseCIDR("0.0.0.0/8"),     // "this" network
+		mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+		mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+		mustParseCIDR("198.18.0.0/15"), // benchmarking
+	}
+)
+
+// Creates an HTTP client for requests to untrusted URLs. Every request,
+// including each redirect hop, is checked against the allowed schemes and
+// the host allow and deny lists. Unless private networks are allowed,
+// connections to loopback, private and link-local addresses are refused at
+// dial time, after the host has been resolved, so that DNS rebinding can't
+// get around the check.
+//
+// The client is meant to be shared, so that connections get reused. Its
+// timeouts come from settings, with ClientReadTimeout bounding a request as
+// a whole, retries included, and idempotent requests that fail with server
+// errors or transient network errors are retried.
+func NewClient(settings *settings.Settings) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   settings.Http.ClientConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if !settings.Http.ClientAllowPrivateNetworks {
+		dialer.Control = controlDial
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = settings.Http.ClientTLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = settings.Http.ClientResponseHeaderTimeout
+	transport.MaxIdleConns = settings.Http.ClientMaxIdleConns
+	transport.MaxIdleConnsPerHost = settings.Http.ClientMaxIdleConnsPerHost
+
+	return &http.Client{
+		Timeout: settings.Http.ClientReadTimeout,
+		Transport: &retryTransport{
+			settings: settings,
+			next:     &guardedTransport{settings: settings, next: transport},
+		},
+	}
+}
+
+// Checks whether the URL's scheme and host are allowed to be fetched.
+func CheckURL(settings *settings.Settings, u *url.URL) error {
+	allowedSchemes := settings.Http.ClientAllowedSchemes
+	if len(allowedSchemes) == 0 {
+		allowedSchemes = defaultAllowedSchemes
+	}
+	if !slices.Contains(allowedSchemes, strings.ToLower(u.Scheme)) {
+		return fmt.Errorf("%w: scheme %s not allowed", ErrForbiddenOrigin, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrForbiddenOrigin)
+	}
+	if matchHost(settings.Http.ClientDeniedHosts, host) {
+		return fmt.Errorf("%w: host %s denied", ErrForbiddenOrigin, host)
+	}
+	if len(settings.Http.ClientAllowedHosts) > 0 && !matchHost(settings.Http.ClientAllowedHosts, host) {
+		return fmt.Errorf("%w: host %s not allowed", ErrForbiddenOrigin, host)
+	}
+
+	return nil
+}
+
+// guardedTransport checks the URL of each request before passing it on.
+type guardedTransport struct {
+	settings *settings.Settings
+	next     http.RoundTripper
+}
+
+func (gt *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := CheckURL(gt.settings, req.URL); err != nil {
+		return nil, err
+	}
+
+	return gt.next.RoundTrip(req)
+}
+
+// Refuses connections to forbidden addresses. It's called with the already
+// resolved address, right before connecting.
+func controlDial(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || isForbiddenIP(ip) {
+		return fmt.Errorf("%w: address %s not allowed", ErrForbiddenOrigin, host)
+	}
+
+	return nil
+}
+
+func isForbiddenIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, network := range forbiddenNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches the host against patterns such as "example.com" or
+// "*.example.com".
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
@@ -0,0 +1,94 @@
+package fetch_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/fetch"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+func TestClientRejectsLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, err := fetch.NewClient(buildSettings()).Get(server.URL)
+	if !errors.Is(err, fetch.ErrForbiddenOrigin) {
+		t.Errorf("expected forbidden origin error, got: %v", err)
+	}
+}
+
+func TestClientAllowsPrivateNetworks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	settings := buildSettings()
+	settings.Http.ClientAllowPrivateNetworks = true
+
+	res, err := fetch.NewClient(settings).Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+}
+
+func TestClientChecksRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://metadata.internal/latest", http.StatusFound)
+	}))
+	defer server.Close()
+
+	settings := buildSettings()
+	settings.Http.ClientAllowPrivateNetworks = true
+	settings.Http.ClientDeniedHosts = []string{"*.internal"}
+
+	_, err := fetch.NewClient(settings).Get(server.URL)
+	if !errors.Is(err, fetch.ErrForbiddenOrigin) {
+		t.Errorf("expected forbidden origin error, got: %v", err)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	settings := buildSettings()
+	settings.Http.ClientAllowedHosts = []string{"example.com", "*.example.com"}
+	settings.Http.ClientDeniedHosts = []string{"private.example.com"}
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/a.jpg", true},
+		{"http://img.example.com/a.jpg", true},
+		{"https://IMG.EXAMPLE.COM/a.jpg", true},
+		{"https://private.example.com/a.jpg", false},
+		{"https://example.org/a.jpg", false},
+		{"file:///etc/passwd", false},
+		{"ftp://example.com/a.jpg", false},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		err := fetch.CheckURL(settings, u)
+		if test.allowed && err != nil {
+			t.Errorf("expected %s to be allowed, got: %v", test.url, err)
+		}
+		if !test.allowed && !errors.Is(err, fetch.ErrForbiddenOrigin) {
+			t.Errorf("expected %s to be forbidden, got: %v", test.url, err)
+		}
+	}
+}
+
+func buildSettings() *settings.Settings {
+	return &settings.Settings{
+		Service: &settings.ServiceSettings{},
+		Auth:    &settings.AuthSettings{},
+		Http:    &settings.HttpSettings{},
+	}
+}
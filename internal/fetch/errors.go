@@ -0,0 +1,13 @@
+package fetch
+
+import "fmt"
+
+// StatusError is returned when the origin responds with a status other
+// than 200 OK.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("non-200 status: %d", e.StatusCode)
+}
@@ -0,0 +1,78 @@
+package fetch
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+// retryTransport retries idempotent requests that failed with a server
+// error or a transient network error, backing off exponentially, with
+// jitter, between attempts. Each request is retried at most
+// ClientRetryMax times.
+type retryTransport struct {
+	settings *settings.Settings
+	next     http.RoundTripper
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req.Method) {
+		return rt.next.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := rt.next.RoundTrip(req)
+		if attempt >= rt.settings.Http.ClientRetryMax || !isRetryable(res, err) {
+			return res, err
+		}
+
+		if res != nil {
+			// drain the body so that the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(Backoff(rt.settings.Http.ClientRetryBackoff, rt.settings.Http.ClientRetryMaxBackoff, attempt)):
+		}
+	}
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isRetryable(res *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.Is(err, io.EOF) ||
+			(errors.As(err, &netErr) && netErr.Timeout())
+	}
+
+	return res.StatusCode >= http.StatusInternalServerError && res.StatusCode != http.StatusNotImplemented
+}
+
+// Returns the delay before the given retry attempt, which is exponentially
+// growing from base up to maxDelay, randomized over its upper half.
+func Backoff(base time.Duration, maxDelay time.Duration, attempt int) time.Duration {
+	delay := base << attempt
+	if delay <= 0 || (maxDelay > 0 && delay > maxDelay) {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
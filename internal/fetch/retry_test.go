@@ -0,0 +1,74 @@
+package fetch_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/fetch"
+)
+
+func TestClientRetriesServerErrors(t *testing.T) {
+	tests := []struct {
+		retryMax int
+		status   int
+		attempts int32
+	}{
+		{0, http.StatusServiceUnavailable, 1},
+		{1, http.StatusServiceUnavailable, 2},
+		{2, http.StatusOK, 3},
+		{5, http.StatusOK, 3},
+	}
+
+	for _, test := range tests {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		settings := buildSettings()
+		settings.Http.ClientAllowPrivateNetworks = true
+		settings.Http.ClientRetryMax = test.retryMax
+		settings.Http.ClientRetryBackoff = time.Millisecond
+
+		res, err := fetch.NewClient(settings).Get(server.URL)
+		server.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status || attempts.Load() != test.attempts {
+			t.Errorf("unexpected outcome with %d retries: status %d after %d attempts", test.retryMax, res.StatusCode, attempts.Load())
+		}
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	settings := buildSettings()
+	settings.Http.ClientAllowPrivateNetworks = true
+	settings.Http.ClientRetryMax = 3
+
+	res, err := fetch.NewClient(settings).Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+
+	if attempts.Load() != 1 {
+		t.Errorf("expected a single attempt, got: %d", attempts.Load())
+	}
+}
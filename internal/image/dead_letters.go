@@ -0,0 +1,96 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Keeps the memory dead-letter store from growing without bounds; the oldest
+// dead letters are dropped first.
+const maxMemoryDeadLetters = 10000
+
+// Returned when a dead letter doesn't exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is an async resize job that failed, and kept failing until it
+// ran out of attempts. It's kept until it's requeued.
+type DeadLetter struct {
+	ID        string     `json:"id"`
+	Job       *ResizeJob `json:"job"`
+	Error     string     `json:"error"`
+	ErrorCode string     `json:"error_code"`
+	Attempts  int        `json:"attempts"`
+	FailedAt  time.Time  `json:"failed_at"`
+}
+
+type DeadLetterStoreAdapter interface {
+	Add(ctx context.Context, letter *DeadLetter) error
+	// Returns up to limit dead letters, most recent first, skipping the
+	// first offset ones.
+	List(ctx context.Context, offset int, limit int) ([]*DeadLetter, error)
+	Get(ctx context.Context, id string) (*DeadLetter, bool)
+	Delete(ctx context.Context, id string) error
+}
+
+// MemoryDeadLetterStore keeps dead letters in memory.
+type MemoryDeadLetterStore struct {
+	letters map[string]*DeadLetter
+	order   []string // IDs, oldest first
+	mu      sync.RWMutex
+}
+
+func NewMemoryDeadLetterStore() DeadLetterStoreAdapter {
+	return &MemoryDeadLetterStore{letters: make(map[string]*DeadLetter)}
+}
+
+func (store *MemoryDeadLetterStore) Add(_ context.Context, letter *DeadLetter) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(store.order) >= maxMemoryDeadLetters {
+		delete(store.letters, store.order[0])
+		store.order = store.order[1:]
+	}
+
+	store.letters[letter.ID] = letter
+	store.order = append(store.order, letter.ID)
+
+	return nil
+}
+
+func (store *MemoryDeadLetterStore) List(_ context.Context, offset int, limit int) ([]*DeadLetter, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	letters := []*DeadLetter{}
+	for i := len(store.order) - 1 - offset; i >= 0 && len(letters) < limit; i-- {
+		letters = append(letters, store.letters[store.order[i]])
+	}
+
+	return letters, nil
+}
+
+func (store *MemoryDeadLetterStore) Get(_ context.Context, id string) (*DeadLetter, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	letter, ok := store.letters[id]
+	return letter, ok
+}
+
+func (store *MemoryDeadLetterStore) Delete(_ context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.letters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+
+	delete(store.letters, id)
+	store.order = slices.DeleteFunc(store.order, func(other string) bool { return other == id })
+
+	return nil
+}
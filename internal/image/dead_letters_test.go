@@ -0,0 +1,81 @@
+package image_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+
+	"github.com/okulik/img-resize/internal/image"
+)
+
+func TestMemoryDeadLetterStore(t *testing.T) {
+	store := image.NewMemoryDeadLetterStore()
+	ctx := context.Background()
+
+	for i := range 3 {
+		letter := &image.DeadLetter{ID: fmt.Sprint(i), Job: &image.ResizeJob{URL: "https://example.com/a.jpg"}}
+		if err := store.Add(ctx, letter); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	letters, _ := store.List(ctx, 1, 5)
+	if len(letters) != 2 || letters[0].ID != "1" || letters[1].ID != "0" {
+		t.Errorf("unexpected dead letters: %v", letters)
+	}
+
+	if err := store.Delete(ctx, "1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, ok := store.Get(ctx, "1"); ok {
+		t.Error("expected dead letter to be deleted")
+	}
+
+	if err := store.Delete(ctx, "1"); !errors.Is(err, image.ErrDeadLetterNotFound) {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+
+	letters, _ = store.List(ctx, 0, 5)
+	if len(letters) != 2 || letters[0].ID != "2" || letters[1].ID != "0" {
+		t.Errorf("unexpected dead letters: %v", letters)
+	}
+}
+
+func TestRedisDeadLetterStore(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	store := image.NewRedisDeadLetterStore(db)
+	ctx := context.Background()
+	data := `{"id":"1","job":{"batch_id":"batch","url":"https://example.com/a.jpg","transformations":null,"image_indexes":null,"attempt":3},"error":"","error_code":"","attempts":3,"failed_at":"2026-01-01T00:00:00Z"}`
+
+	mock.ExpectZRevRange("img-resize:dead-letters:index", 0, 9).SetVal([]string{"1", "2"})
+	mock.ExpectHMGet("img-resize:dead-letters", "1", "2").SetVal([]any{data, nil})
+	mock.ExpectHDel("img-resize:dead-letters", "1").SetVal(1)
+	mock.ExpectZRem("img-resize:dead-letters:index", "1").SetVal(1)
+	mock.ExpectHDel("img-resize:dead-letters", "1").SetVal(0)
+
+	letters, err := store.List(ctx, 0, 10)
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("unexpected dead letters: %v, %v", letters, err)
+	}
+
+	if letters[0].Job.URL != "https://example.com/a.jpg" || letters[0].Attempts != 3 || !letters[0].FailedAt.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected dead letter: %v", letters[0])
+	}
+
+	if err := store.Delete(ctx, "1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := store.Delete(ctx, "1"); !errors.Is(err, image.ErrDeadLetterNotFound) {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,91 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/okulik/img-resize/internal/fetch"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+var (
+	// Returned when the source image can't be fetched.
+	ErrFetchFailed = errors.New("image fetch failed")
+	// Returned when the source image can't be decoded.
+	ErrDecodeFailed = errors.New("failed to decode")
+	// Returned when an async resize job can't be enqueued.
+	ErrQueueFull = errors.New("image resize queue full, try later")
+	// Returned when receiving from a job queue that has been closed.
+	ErrQueueClosed = errors.New("image resize queue closed")
+	// Returned when an async resize job can't be enqueued, because the queue
+	// failed.
+	ErrQueueUnavailable = errors.New("image resize queue unavailable, try later")
+	// Returned when async workers are asked for, but async resize is disabled.
+	ErrAsyncResizeDisabled = errors.New("async resize is disabled")
+	// Returned when async workers are asked for after the resizer shut down.
+	ErrResizerStopped = errors.New("resizer is shut down")
+	// Reported for images whose resize was cancelled.
+	ErrResizeCancelled = errors.New("image resize cancelled")
+	// Returned when cancelling a job whose images are all done.
+	ErrJobFinished = errors.New("job is already finished")
+)
+
+// Builds the response for an image that failed to resize, with the error
+// classified into one of the model.ErrorCode values.
+func FailureResponse(err error) model.ResizeResponse {
+	resp := model.ResizeResponse{Result: statusFailure, Error: err.Error()}
+
+	var statusErr *fetch.StatusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		resp.ErrorCode = model.ErrorCodeOriginStatus
+		resp.OriginStatus = statusErr.StatusCode
+	case errors.Is(err, ErrQueueFull):
+		resp.ErrorCode = model.ErrorCodeQueueFull
+	case errors.Is(err, ErrQueueClosed), errors.Is(err, ErrQueueUnavailable):
+		resp.ErrorCode = model.ErrorCodeQueueUnavailable
+	case errors.Is(err, ErrResizeCancelled):
+		resp.ErrorCode = model.ErrorCodeCancelled
+	case errors.Is(err, ErrImageTooLarge):
+		resp.ErrorCode = model.ErrorCodeTooLarge
+	case errors.Is(err, ErrDecodeFailed), errors.Is(err, ErrUnsupportedFormat):
+		resp.ErrorCode = model.ErrorCodeDecodeFailed
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled),
+		errors.As(err, &netErr) && netErr.Timeout():
+		resp.ErrorCode = model.ErrorCodeTimeout
+	case errors.Is(err, ErrFetchFailed):
+		resp.ErrorCode = model.ErrorCodeFetchFailed
+	default:
+		resp.ErrorCode = model.ErrorCodeResizeFailed
+	}
+
+	return resp
+}
+
+// Reports whether an async job that failed with the error may succeed when
+// retried. Network errors, timeouts, server errors and rate limiting by the
+// origin are retryable; errors about the image itself, or about an origin
+// refusing it, are permanent.
+func isRetryable(err error) bool {
+	var statusErr *fetch.StatusError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &statusErr):
+		code := statusErr.StatusCode
+		return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+	case errors.Is(err, fetch.ErrForbiddenOrigin), errors.Is(err, ErrImageTooLarge),
+		errors.Is(err, ErrDecodeFailed), errors.Is(err, ErrUnsupportedFormat):
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.Is(err, ErrFetchFailed):
+		return true
+	default:
+		return false
+	}
+}
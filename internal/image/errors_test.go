@@ -0,0 +1,60 @@
+package image_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+func TestResizerProcessReportsErrors(t *testing.T) {
+	imageServer := buildImageServer(t, 40, 20)
+	defer imageServer.Close()
+
+	missingServer := httptest.NewServer(http.NotFoundHandler())
+	defer missingServer.Close()
+
+	htmlServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer htmlServer.Close()
+
+	settings := buildSettings()
+	settings.Service.MaxImageWidth = 30
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(settings, imageCache)
+
+	urls := []string{missingServer.URL, htmlServer.URL, imageServer.URL, "ftp://example.com/a.jpg"}
+	responses, err := resizer.Process(&model.ResizeRequest{URLs: urls}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(responses) != len(urls) {
+		t.Fatalf("expected one response per url, got: %v", responses)
+	}
+
+	expected := []struct {
+		code   string
+		status int
+	}{
+		{model.ErrorCodeOriginStatus, http.StatusNotFound},
+		{model.ErrorCodeDecodeFailed, 0},
+		{model.ErrorCodeTooLarge, 0},
+		{model.ErrorCodeFetchFailed, 0},
+	}
+
+	for i, resp := range responses {
+		if resp.URL != urls[i] || resp.Result != "failure" || resp.Error == "" || resp.ID == "" {
+			t.Errorf("unexpected response for %s: %v", urls[i], resp)
+		}
+
+		if resp.ErrorCode != expected[i].code || resp.OriginStatus != expected[i].status {
+			t.Errorf("unexpected error for %s: %s, %d", urls[i], resp.ErrorCode, resp.OriginStatus)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	goimage "image"
+	"image/draw"
+)
+
+const (
+	exifOrientationTag = 0x0112
+	exifTypeShort      = 3
+)
+
+// Returns the EXIF orientation (1 to 8) of the JPEG data, or 1 if the data
+// carries no valid orientation.
+func exifOrientation(data []byte) int {
+	for _, segment := range jpegSegments(data) {
+		payload := segment.payload()
+		if segment.Marker != markerAPP1 || !bytes.HasPrefix(payload, exifHeader) {
+			continue
+		}
+
+		tiff := payload[len(exifHeader):]
+		pos, order, ok := findExifOrientation(tiff)
+		if !ok {
+			return 1
+		}
+
+		orientation := int(order.Uint16(tiff[pos:]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+
+		return orientation
+	}
+
+	return 1
+}
+
+// Overwrites the orientation stored in the EXIF TIFF structure, if any.
+func setExifOrientation(tiff []byte, orientation uint16) {
+	if pos, order, ok := findExifOrientation(tiff); ok {
+		order.PutUint16(tiff[pos:], orientation)
+	}
+}
+
+// Looks up the orientation entry in the first IFD of the EXIF TIFF structure
+// and returns the position of its value.
+func findExifOrientation(tiff []byte) (int, binary.ByteOrder, bool) {
+	if len(tiff) < 8 {
+		return 0, nil, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, nil, false
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0, nil, false
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		if order.Uint16(tiff[entry:]) == exifOrientationTag && order.Uint16(tiff[entry+2:]) == exifTypeShort {
+			return entry + 8, order, true
+		}
+	}
+
+	return 0, nil, false
+}
+
+// Rotates and flips the image so that it's displayed upright, according to
+// its EXIF orientation.
+func orientImage(img goimage.Image, orientation int) goimage.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := goimage.NewNRGBA(goimage.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		// orientations 5 to 8 swap the dimensions
+		dstW, dstH = h, w
+	}
+	dst := goimage.NewNRGBA(goimage.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotate by 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertically
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate by 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate by 90 counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
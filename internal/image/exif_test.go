@@ -0,0 +1,95 @@
+package image_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// An orientation entry holding the "normal" orientation, little endian.
+var normalOrientationEntry = []byte("\x12\x01\x03\x00\x01\x00\x00\x00\x01\x00")
+
+func TestResizerHonorsExifOrientation(t *testing.T) {
+	server := buildExifImageServer(t, 40, 20, 6)
+	defer server.Close()
+
+	data := processJPEG(t, server.URL, model.Transformation{})
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode resized image: %v", err)
+	}
+
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 {
+		t.Errorf("expected image to be rotated, got bounds: %v", img.Bounds())
+	}
+
+	if bytes.Contains(data, []byte("Exif\x00\x00")) {
+		t.Error("expected metadata to be stripped")
+	}
+}
+
+func TestResizerKeepsMetadata(t *testing.T) {
+	server := buildExifImageServer(t, 40, 20, 6)
+	defer server.Close()
+
+	data := processJPEG(t, server.URL, model.Transformation{Metadata: "keep"})
+
+	if !bytes.Contains(data, []byte("Exif\x00\x00")) {
+		t.Fatal("expected exif metadata to be kept")
+	}
+
+	if !bytes.Contains(data, normalOrientationEntry) {
+		t.Error("expected exif orientation to be reset")
+	}
+}
+
+func processJPEG(t *testing.T, url string, transformation model.Transformation) []byte {
+	t.Helper()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: []string{url}, Transformation: transformation})
+	resizer.Start()
+	resizer.Shutdown()
+
+	data, ok := imageCache.Get(context.Background(), responses[0].ID)
+	if !ok {
+		t.Fatalf("resized image not cached")
+	}
+
+	return data
+}
+
+func buildExifImageServer(t *testing.T, width int, height int, orientation uint16) *httptest.Server {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, buildTestImage(width, height), nil); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// a little endian TIFF structure with a single orientation entry
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint16(tiff[18:], orientation)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	data = append(data, segment...)
+	data = append(data, buf.Bytes()[2:]...)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(data)
+	}))
+}
@@ -0,0 +1,136 @@
+package image
+
+import (
+	goimage "image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	jpgresize "github.com/nfnt/resize"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// Fit modes, modelled after the CSS object-fit property. With the default
+// fit the image is stretched to the requested dimensions, which is the same
+// as fill.
+const (
+	fitFill    = "fill"
+	fitContain = "contain"
+	fitCover   = "cover"
+	fitInside  = "inside"
+	fitOutside = "outside"
+
+	gravityCenter = "center"
+)
+
+var (
+	fits      = []string{fitFill, fitContain, fitCover, fitInside, fitOutside}
+	gravities = []string{
+		gravityCenter, "north", "northeast", "east", "southeast",
+		"south", "southwest", "west", "northwest",
+	}
+)
+
+// Resizes the image according to the transformation's fit mode. When either
+// width or height is 0, every fit mode resizes respecting the aspect ratio.
+// The background color fills the padding added by the contain fit.
+func fitImage(img goimage.Image, t model.Transformation, background color.Color) goimage.Image {
+	width, height := fitDimensions(img.Bounds().Size(), t)
+	resized := jpgresize.Resize(width, height, img, jpgresize.Lanczos3)
+	if t.Width == 0 || t.Height == 0 {
+		return resized
+	}
+
+	switch t.Fit {
+	case fitContain:
+		return padImage(resized, int(t.Width), int(t.Height), t.Gravity, background)
+	case fitCover:
+		return cropImage(resized, int(t.Width), int(t.Height), t.Gravity)
+	default:
+		return resized
+	}
+}
+
+// Returns the dimensions an image of the given size gets resized to by the
+// transformation, before the cover fit crops it or the contain fit pads it.
+// They may be far larger than the requested ones, for instance when only
+// the width of a tall and narrow image is requested.
+func fitDimensions(size goimage.Point, t model.Transformation) (uint, uint) {
+	srcWidth, srcHeight := float64(size.X), float64(size.Y)
+
+	// as the resize package does when either width or height is 0
+	switch {
+	case t.Width == 0 && t.Height == 0:
+		return uint(size.X), uint(size.Y)
+	case t.Width == 0:
+		return uint(0.7 + srcWidth*float64(t.Height)/srcHeight), t.Height
+	case t.Height == 0:
+		return t.Width, uint(0.7 + srcHeight*float64(t.Width)/srcWidth)
+	}
+
+	scaleX := float64(t.Width) / srcWidth
+	scaleY := float64(t.Height) / srcHeight
+
+	switch t.Fit {
+	case fitContain, fitInside:
+		return scaleDimensions(size, math.Min(scaleX, scaleY))
+	case fitCover, fitOutside:
+		return scaleDimensions(size, math.Max(scaleX, scaleY))
+	default:
+		return t.Width, t.Height
+	}
+}
+
+func scaleDimensions(size goimage.Point, scale float64) (uint, uint) {
+	width := max(1, uint(math.Round(float64(size.X)*scale)))
+	height := max(1, uint(math.Round(float64(size.Y)*scale)))
+
+	return width, height
+}
+
+// Cuts a width x height window out of the image, placed according to gravity.
+func cropImage(img goimage.Image, width int, height int, gravity string) goimage.Image {
+	bounds := img.Bounds()
+	offset := gravityOffset(gravity, bounds.Dx()-width, bounds.Dy()-height)
+
+	dst := goimage.NewNRGBA(goimage.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min.Add(offset), draw.Src)
+
+	return dst
+}
+
+// Places the image on a width x height canvas, according to gravity.
+func padImage(img goimage.Image, width int, height int, gravity string, background color.Color) goimage.Image {
+	bounds := img.Bounds()
+	offset := gravityOffset(gravity, width-bounds.Dx(), height-bounds.Dy())
+
+	dst := goimage.NewNRGBA(goimage.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), goimage.NewUniform(background), goimage.Point{}, draw.Src)
+	draw.Draw(dst, bounds.Sub(bounds.Min).Add(offset), img, bounds.Min, draw.Over)
+
+	return dst
+}
+
+// Returns where a region of excessX x excessY pixels, left over between an
+// image and its target dimensions, is split according to gravity.
+func gravityOffset(gravity string, excessX int, excessY int) goimage.Point {
+	offset := goimage.Point{X: excessX / 2, Y: excessY / 2}
+
+	switch {
+	case strings.HasSuffix(gravity, "west"):
+		offset.X = 0
+	case strings.HasSuffix(gravity, "east"):
+		offset.X = excessX
+	}
+
+	switch {
+	case strings.HasPrefix(gravity, "north"):
+		offset.Y = 0
+	case strings.HasPrefix(gravity, "south"):
+		offset.Y = excessY
+	}
+
+	return offset
+}
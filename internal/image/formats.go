@@ -0,0 +1,237 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	goimage "image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// Returned when the fetched bytes don't match any registered image format.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// ImageFormat describes a raster image format known to the resizer. Magic
+// holds the signatures the format is sniffed by; a "?" matches any byte.
+// Formats without an Encode function can only be used as sources.
+type ImageFormat struct {
+	Name         string
+	ContentType  string
+	Magic        []string
+	Decode       func(io.Reader) (goimage.Image, error)
+	DecodeConfig func(io.Reader) (goimage.Config, error)
+	Encode       func(w io.Writer, img goimage.Image, quality int) error
+}
+
+// FormatRegistry is a set of image formats that source images are sniffed
+// and decoded with, and resized images are encoded with. It is safe for
+// concurrent use.
+type FormatRegistry struct {
+	formats []*ImageFormat
+	mu      sync.RWMutex
+}
+
+var defaultFormats = NewFormatRegistry(
+	&ImageFormat{
+		Name:         "jpeg",
+		ContentType:  "image/jpeg",
+		Magic:        []string{"\xff\xd8"},
+		Decode:       jpeg.Decode,
+		DecodeConfig: jpeg.DecodeConfig,
+		Encode:       encodeJPEG,
+	},
+	&ImageFormat{
+		Name:         "png",
+		ContentType:  "image/png",
+		Magic:        []string{"\x89PNG\r\n\x1a\n"},
+		Decode:       png.Decode,
+		DecodeConfig: png.DecodeConfig,
+		Encode:       encodePNG,
+	},
+	&ImageFormat{
+		Name:         "gif",
+		ContentType:  "image/gif",
+		Magic:        []string{"GIF87a", "GIF89a"},
+		Decode:       gif.Decode,
+		DecodeConfig: gif.DecodeConfig,
+		Encode:       encodeGIF,
+	},
+	&ImageFormat{
+		Name:         "webp",
+		ContentType:  "image/webp",
+		Magic:        []string{"RIFF????WEBPVP8"},
+		Decode:       webp.Decode,
+		DecodeConfig: webp.DecodeConfig,
+	},
+	&ImageFormat{
+		Name:         "bmp",
+		ContentType:  "image/bmp",
+		Magic:        []string{"BM????\x00\x00\x00\x00"},
+		Decode:       bmp.Decode,
+		DecodeConfig: bmp.DecodeConfig,
+	},
+	&ImageFormat{
+		Name:         "tiff",
+		ContentType:  "image/tiff",
+		Magic:        []string{"II*\x00", "MM\x00*"},
+		Decode:       tiff.Decode,
+		DecodeConfig: tiff.DecodeConfig,
+	},
+)
+
+// Creates a new format registry holding the given formats.
+func NewFormatRegistry(formats ...*ImageFormat) *FormatRegistry {
+	fr := &FormatRegistry{}
+	for _, f := range formats {
+		fr.Register(f)
+	}
+
+	return fr
+}
+
+// Returns the registry used by resizers created with NewResizer.
+func DefaultFormats() *FormatRegistry {
+	return defaultFormats
+}
+
+// Adds a format to the registry. A format registered under an existing name
+// replaces the previous one.
+func (fr *FormatRegistry) Register(format *ImageFormat) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	for i, f := range fr.formats {
+		if f.Name == format.Name {
+			fr.formats[i] = format
+			return
+		}
+	}
+	fr.formats = append(fr.formats, format)
+}
+
+// Returns the format registered under the given name.
+func (fr *FormatRegistry) Lookup(name string) (*ImageFormat, bool) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	for _, f := range fr.formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+// Identifies the format of the image data by its leading magic bytes.
+func (fr *FormatRegistry) Sniff(data []byte) (*ImageFormat, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	for _, f := range fr.formats {
+		for _, magic := range f.Magic {
+			if matchMagic(magic, data) {
+				return f, nil
+			}
+		}
+	}
+
+	return nil, ErrUnsupportedFormat
+}
+
+// Returns the format registered under the given name if it can be used as
+// an output format.
+func (fr *FormatRegistry) LookupEncoder(name string) (*ImageFormat, error) {
+	format, ok := fr.Lookup(name)
+	if !ok || format.Encode == nil {
+		return nil, fmt.Errorf("%w: no %s encoder", ErrUnsupportedFormat, name)
+	}
+
+	return format, nil
+}
+
+// Sniffs the format of the image data and decodes its dimensions and color
+// model, without decoding the whole image.
+func (fr *FormatRegistry) DecodeConfig(data []byte) (goimage.Config, *ImageFormat, error) {
+	format, err := fr.Sniff(data)
+	if err != nil {
+		return goimage.Config{}, nil, err
+	}
+
+	config, err := format.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return config, format, fmt.Errorf("%w %s header: %v", ErrDecodeFailed, format.Name, err)
+	}
+
+	return config, format, nil
+}
+
+// Sniffs the format of the image data and decodes it.
+func (fr *FormatRegistry) Decode(data []byte) (goimage.Image, *ImageFormat, error) {
+	format, err := fr.Sniff(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := format.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, format, fmt.Errorf("%w %s: %v", ErrDecodeFailed, format.Name, err)
+	}
+
+	return img, format, nil
+}
+
+// Returns the content type of the image data, or a generic binary content
+// type if the data doesn't match any of the default formats.
+func DetectContentType(data []byte) string {
+	format, err := defaultFormats.Sniff(data)
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return format.ContentType
+}
+
+func encodeJPEG(w io.Writer, img goimage.Image, quality int) error {
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// PNG is lossless, so quality is ignored.
+func encodePNG(w io.Writer, img goimage.Image, _ int) error {
+	return png.Encode(w, img)
+}
+
+// GIF quality maps to the size of the palette, from 2 up to 256 colors.
+func encodeGIF(w io.Writer, img goimage.Image, quality int) error {
+	if quality == 0 {
+		return gif.Encode(w, img, nil)
+	}
+
+	return gif.Encode(w, img, &gif.Options{NumColors: max(2, quality*256/100)})
+}
+
+func matchMagic(magic string, data []byte) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,80 @@
+package image_test
+
+import (
+	"bytes"
+	"errors"
+	goimage "image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+
+	"github.com/okulik/img-resize/internal/image"
+)
+
+func TestFormatRegistryDecode(t *testing.T) {
+	encoders := map[string]func(io.Writer, goimage.Image) error{
+		"jpeg": func(w io.Writer, m goimage.Image) error { return jpeg.Encode(w, m, nil) },
+		"png":  png.Encode,
+		"gif":  func(w io.Writer, m goimage.Image) error { return gif.Encode(w, m, nil) },
+		"bmp":  bmp.Encode,
+		"tiff": func(w io.Writer, m goimage.Image) error { return tiff.Encode(w, m, nil) },
+	}
+
+	for name, encode := range encoders {
+		buf := bytes.Buffer{}
+		if err := encode(&buf, buildTestImage(4, 3)); err != nil {
+			t.Fatalf("failed to encode %s: %v", name, err)
+		}
+
+		img, format, err := image.DefaultFormats().Decode(buf.Bytes())
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", name, err)
+		}
+
+		if format.Name != name {
+			t.Errorf("unexpected format sniffed for %s: %s", name, format.Name)
+		}
+
+		if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 3 {
+			t.Errorf("unexpected %s image bounds: %v", name, img.Bounds())
+		}
+	}
+}
+
+func TestFormatRegistrySniffUnsupported(t *testing.T) {
+	_, err := image.DefaultFormats().Sniff([]byte("<html></html>"))
+	if !errors.Is(err, image.ErrUnsupportedFormat) {
+		t.Errorf("expected unsupported format error, got: %v", err)
+	}
+}
+
+func TestFormatRegistryRegister(t *testing.T) {
+	registry := image.NewFormatRegistry()
+	registry.Register(&image.ImageFormat{Name: "fake", Magic: []string{"FA?E"}})
+
+	format, err := registry.Sniff([]byte("FAKE image"))
+	if err != nil || format.Name != "fake" {
+		t.Errorf("expected fake format to be sniffed, got: %v", err)
+	}
+
+	if _, err := registry.Sniff([]byte("FA")); err == nil {
+		t.Error("expected short data not to match")
+	}
+}
+
+func buildTestImage(width int, height int) goimage.Image {
+	img := goimage.NewNRGBA(goimage.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 60), G: uint8(y * 80), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
@@ -0,0 +1,248 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+// JobQueue holds async resize jobs until a worker receives them. Jobs are
+// delivered at least once: a job that's received is done only once it's
+// acknowledged, which lets durable queues hand unacknowledged jobs to
+// another worker.
+type JobQueue interface {
+	// Adds the job to the queue, failing with ErrQueueFull if it's full.
+	Enqueue(ctx context.Context, job *ResizeJob) error
+	// Adds the job to the queue once the delay has passed.
+	EnqueueAfter(ctx context.Context, job *ResizeJob, delay time.Duration) error
+	// Blocks until a job is available, failing with ErrQueueClosed once the
+	// queue is closed.
+	Receive(ctx context.Context) (*ResizeJob, error)
+	// Returns the number of jobs in the queue.
+	Len(ctx context.Context) (int, error)
+	// Acknowledges that the received job is done.
+	Ack(ctx context.Context, job *ResizeJob) error
+	// Keeps the received job, while it's being run, from being handed to
+	// another worker for another visibility timeout.
+	Extend(ctx context.Context, job *ResizeJob) error
+	// Stops the queue from taking new jobs.
+	Close() error
+	// Empties the closed queue, returning its jobs along with the given
+	// received jobs that weren't acknowledged, for them to be kept until the
+	// queue is back. Durable queues keep their jobs, and deliver the
+	// unacknowledged ones again, so they return none.
+	Drain(ctx context.Context, unacked []*ResizeJob) ([]*ResizeJob, error)
+}
+
+// MemoryJobQueue is a bounded in-memory job queue, with a lane per
+// priority. Its jobs are lost when the process exits, and they can't be
+// shared with other instances.
+type MemoryJobQueue struct {
+	lanes     []*memoryLane
+	scheduler *laneScheduler
+	fullWait  time.Duration
+	ready     chan struct{}              // holds a token per queued job
+	delayed   map[*ResizeJob]*time.Timer // jobs waiting for their delay to pass
+	closed    bool
+	mu        sync.Mutex
+}
+
+// memoryLane holds the queued jobs of a priority, oldest first.
+type memoryLane struct {
+	laneConfig
+	jobs  []*ResizeJob
+	slots semaphore // holds a slot per queued job, bounding the lane
+}
+
+func NewMemoryJobQueue(settings *settings.Settings) JobQueue {
+	configs := laneConfigs(settings)
+	q := &MemoryJobQueue{
+		scheduler: newLaneScheduler(configs),
+		fullWait:  settings.Service.QueueFullWait,
+		delayed:   make(map[*ResizeJob]*time.Timer),
+	}
+
+	size := 0
+	for _, config := range configs {
+		q.lanes = append(q.lanes, &memoryLane{laneConfig: config, slots: newSemaphore(config.size)})
+		size += config.size
+	}
+	q.ready = make(chan struct{}, size)
+
+	return q
+}
+
+func (q *MemoryJobQueue) Enqueue(ctx context.Context, job *ResizeJob) error {
+	lane := q.lanes[laneIndex(job.Priority)]
+	if err := q.takeSlot(ctx, lane); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		lane.slots.Release()
+		return ErrQueueClosed
+	}
+
+	lane.jobs = append(lane.jobs, job)
+	q.ready <- struct{}{}
+	// a delayed job is no longer delayed once it's back in its lane
+	delete(q.delayed, job)
+
+	return nil
+}
+
+// Takes a slot in the lane for a job. If the lane is full, the job either
+// waits for a while for a slot or is rejected, depending on the lane's
+// policy.
+func (q *MemoryJobQueue) takeSlot(ctx context.Context, lane *memoryLane) error {
+	q.mu.Lock()
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case lane.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if lane.fullPolicy != fullPolicyWait {
+		return ErrQueueFull
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, q.fullWait)
+	defer cancel()
+	if err := lane.slots.Acquire(ctx); err != nil {
+		return ErrQueueFull
+	}
+
+	return nil
+}
+
+// The job is kept in memory until the delay has passed. If the queue is full
+// by then, the job is dropped. If it's closed, the job stays delayed, so that
+// it's drained along with the others.
+func (q *MemoryJobQueue) EnqueueAfter(_ context.Context, job *ResizeJob, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	q.delayed[job] = time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		_, ok := q.delayed[job]
+		closed := q.closed
+		q.mu.Unlock()
+		if !ok || closed {
+			return
+		}
+
+		err := q.Enqueue(context.Background(), job)
+		if err != nil && !errors.Is(err, ErrQueueClosed) {
+			log.Printf("dropping delayed resize job of %s: %v", job.URL, err)
+			q.mu.Lock()
+			delete(q.delayed, job)
+			q.mu.Unlock()
+		}
+	})
+
+	return nil
+}
+
+// Jobs enqueued before the queue was closed are still received, so that
+// workers drain the queue before they stop. The lane a job is received from
+// is picked by weighted round-robin among the lanes that have jobs.
+func (q *MemoryJobQueue) Receive(ctx context.Context) (*ResizeJob, error) {
+	select {
+	case _, ok := <-q.ready:
+		if !ok {
+			return nil, ErrQueueClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lane := q.lanes[q.scheduler.next(func(i int) bool { return len(q.lanes[i].jobs) > 0 })]
+	job := lane.jobs[0]
+	lane.jobs[0] = nil
+	lane.jobs = lane.jobs[1:]
+	lane.slots.Release()
+
+	return job, nil
+}
+
+// Jobs being run are acknowledged as soon as they're received, so only the
+// jobs waiting to be received are counted.
+func (q *MemoryJobQueue) Len(_ context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	size := 0
+	for _, lane := range q.lanes {
+		size += len(lane.jobs)
+	}
+
+	return size, nil
+}
+
+func (q *MemoryJobQueue) Ack(_ context.Context, _ *ResizeJob) error {
+	return nil
+}
+
+// Received jobs are never handed out again, so there's nothing to extend.
+func (q *MemoryJobQueue) Extend(_ context.Context, _ *ResizeJob) error {
+	return nil
+}
+
+func (q *MemoryJobQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.ready)
+	}
+
+	return nil
+}
+
+// Delayed jobs are drained too, and are due right away once they're back.
+func (q *MemoryJobQueue) Drain(_ context.Context, unacked []*ResizeJob) ([]*ResizeJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		return nil, errors.New("can't drain an open queue")
+	}
+
+	jobs := append([]*ResizeJob(nil), unacked...)
+	for _, lane := range q.lanes {
+		jobs = append(jobs, lane.jobs...)
+		lane.jobs = nil
+	}
+	for job, timer := range q.delayed {
+		timer.Stop()
+		jobs = append(jobs, job)
+	}
+	clear(q.delayed)
+
+	// The tokens of the drained jobs go too, so receiving fails right away
+	for range q.ready {
+	}
+
+	return jobs, nil
+}
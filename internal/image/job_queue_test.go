@@ -0,0 +1,198 @@
+package image_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/image"
+)
+
+func TestMemoryJobQueue(t *testing.T) {
+	ctx := context.Background()
+	queue := image.NewMemoryJobQueue(buildSettings())
+
+	if err := queue.Enqueue(ctx, &image.ResizeJob{URL: "https://example.com/a.jpg"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := queue.Enqueue(ctx, &image.ResizeJob{URL: "https://example.com/b.jpg"}); !errors.Is(err, image.ErrQueueClosed) {
+		t.Errorf("expected queue closed error, got: %v", err)
+	}
+
+	// jobs enqueued before the queue was closed are still received
+	job, err := queue.Receive(ctx)
+	if err != nil || job.URL != "https://example.com/a.jpg" {
+		t.Errorf("unexpected job: %v, %v", job, err)
+	}
+
+	if _, err := queue.Receive(ctx); !errors.Is(err, image.ErrQueueClosed) {
+		t.Errorf("expected queue closed error, got: %v", err)
+	}
+}
+
+func TestMemoryJobQueueDrain(t *testing.T) {
+	ctx := context.Background()
+	queue := image.NewMemoryJobQueue(buildSettings())
+
+	_ = queue.Enqueue(ctx, &image.ResizeJob{URL: "https://example.com/a.jpg"})
+	_ = queue.Enqueue(ctx, &image.ResizeJob{URL: "https://example.com/b.jpg", Priority: "bulk"})
+	_ = queue.EnqueueAfter(ctx, &image.ResizeJob{URL: "https://example.com/c.jpg"}, time.Hour)
+
+	if _, err := queue.Drain(ctx, nil); err == nil {
+		t.Error("expected an open queue not to be drained")
+	}
+
+	_ = queue.Close()
+	unacked := &image.ResizeJob{URL: "https://example.com/d.jpg"}
+	jobs, err := queue.Drain(ctx, []*image.ResizeJob{unacked})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	urls := []string{}
+	for _, job := range jobs {
+		urls = append(urls, job.URL)
+	}
+	slices.Sort(urls)
+	expected := []string{"https://example.com/a.jpg", "https://example.com/b.jpg", "https://example.com/c.jpg", "https://example.com/d.jpg"}
+	if !slices.Equal(urls, expected) {
+		t.Errorf("unexpected drained jobs: %v", urls)
+	}
+
+	// the drained queue is empty
+	if _, err := queue.Receive(ctx); !errors.Is(err, image.ErrQueueClosed) {
+		t.Errorf("expected queue closed error, got: %v", err)
+	}
+}
+
+func TestMemoryJobQueueDrainsJobsDueAfterClose(t *testing.T) {
+	ctx := context.Background()
+	queue := image.NewMemoryJobQueue(buildSettings())
+
+	_ = queue.EnqueueAfter(ctx, &image.ResizeJob{URL: "https://example.com/a.jpg"}, 10*time.Millisecond)
+	_ = queue.Close()
+
+	// the job comes due while the queue is closed, but not yet drained
+	time.Sleep(50 * time.Millisecond)
+
+	jobs, err := queue.Drain(ctx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(jobs) != 1 || jobs[0].URL != "https://example.com/a.jpg" {
+		t.Errorf("unexpected drained jobs: %v", jobs)
+	}
+}
+
+func TestMemoryJobQueuePriorities(t *testing.T) {
+	ctx := context.Background()
+	s := buildSettings()
+	s.Service.QueueHighWeight = 3
+	s.Service.QueueBulkWeight = 1
+	queue := image.NewMemoryJobQueue(s)
+
+	// bulk jobs are queued first, yet high ones are received first, while
+	// bulk ones still get their share
+	for _, priority := range []string{"bulk", "bulk", "bulk", "bulk", "high", "high", "high", "high"} {
+		if err := queue.Enqueue(ctx, &image.ResizeJob{Priority: priority}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	received := []string{}
+	for range 8 {
+		job, err := queue.Receive(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		received = append(received, job.Priority)
+	}
+
+	expected := []string{"high", "high", "bulk", "high", "high", "bulk", "bulk", "bulk"}
+	if !slices.Equal(received, expected) {
+		t.Errorf("unexpected order: %v", received)
+	}
+}
+
+func TestMemoryJobQueueLaneSizes(t *testing.T) {
+	ctx := context.Background()
+	fill := func(queue image.JobQueue, priority string) int {
+		n := 0
+		for queue.Enqueue(ctx, &image.ResizeJob{Priority: priority}) == nil {
+			n++
+		}
+		return n
+	}
+
+	// the lanes split the size of the whole queue
+	s := buildSettings()
+	s.Service.ResizeQueueSize = 10
+	queue := image.NewMemoryJobQueue(s)
+	sizes := []int{fill(queue, "high"), fill(queue, "normal"), fill(queue, "bulk")}
+	if !slices.Equal(sizes, []int{4, 3, 3}) {
+		t.Errorf("unexpected lane sizes: %v", sizes)
+	}
+	if size, _ := queue.Len(ctx); size != 10 {
+		t.Errorf("expected the queue to hold 10 jobs, got: %d", size)
+	}
+
+	// lanes with a size of their own leave the rest of it to the others
+	s.Service.QueueHighSize = 2
+	queue = image.NewMemoryJobQueue(s)
+	sizes = []int{fill(queue, "high"), fill(queue, "normal"), fill(queue, "bulk")}
+	if !slices.Equal(sizes, []int{2, 4, 4}) {
+		t.Errorf("unexpected lane sizes: %v", sizes)
+	}
+}
+
+func TestMemoryJobQueueFullPolicies(t *testing.T) {
+	ctx := context.Background()
+	s := buildSettings()
+	s.Service.QueueHighSize = 1
+	s.Service.QueueHighFullPolicy = "wait"
+	s.Service.QueueBulkSize = 1
+	s.Service.QueueBulkFullPolicy = "reject"
+	s.Service.QueueFullWait = 10 * time.Millisecond
+	queue := image.NewMemoryJobQueue(s)
+
+	for _, priority := range []string{"high", "bulk"} {
+		if err := queue.Enqueue(ctx, &image.ResizeJob{Priority: priority}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := queue.Enqueue(ctx, &image.ResizeJob{Priority: "bulk"}); !errors.Is(err, image.ErrQueueFull) {
+		t.Errorf("expected queue full error, got: %v", err)
+	}
+
+	// a full lane doesn't affect the others
+	if err := queue.Enqueue(ctx, &image.ResizeJob{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a high job waits for room, and is rejected if it doesn't get any in time
+	start := time.Now()
+	if err := queue.Enqueue(ctx, &image.ResizeJob{Priority: "high"}); !errors.Is(err, image.ErrQueueFull) || time.Since(start) < s.Service.QueueFullWait {
+		t.Errorf("expected queue full error after waiting, got: %v", err)
+	}
+
+	// or else gets it once a job is received
+	s.Service.QueueFullWait = 5 * time.Second
+	queue = image.NewMemoryJobQueue(s)
+	_ = queue.Enqueue(ctx, &image.ResizeJob{Priority: "high"})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = queue.Receive(ctx)
+	}()
+
+	if err := queue.Enqueue(ctx, &image.ResizeJob{Priority: "high"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
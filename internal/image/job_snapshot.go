@@ -0,0 +1,71 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// Returned when saving jobs to a snapshot that has nowhere to keep them.
+var ErrSnapshotDisabled = errors.New("job snapshot is disabled")
+
+// JobSnapshotAdapter keeps the async jobs that were still unprocessed when
+// the resizer shut down, until it's started again.
+type JobSnapshotAdapter interface {
+	Save(ctx context.Context, jobs []*ResizeJob) error
+	// Returns the saved jobs, and removes them from the snapshot.
+	Load(ctx context.Context) ([]*ResizeJob, error)
+}
+
+// FileJobSnapshot keeps the jobs in a JSON file. Without a path, it keeps
+// none.
+type FileJobSnapshot struct {
+	path string
+}
+
+func NewFileJobSnapshot(path string) JobSnapshotAdapter {
+	return &FileJobSnapshot{path: path}
+}
+
+// Jobs are written to a temporary file first, so that a crash while saving
+// doesn't leave a truncated snapshot behind.
+func (s *FileJobSnapshot) Save(_ context.Context, jobs []*ResizeJob) error {
+	if s.path == "" {
+		return ErrSnapshotDisabled
+	}
+
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func (s *FileJobSnapshot) Load(_ context.Context) ([]*ResizeJob, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*ResizeJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, os.Remove(s.path)
+}
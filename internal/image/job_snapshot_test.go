@@ -0,0 +1,45 @@
+package image_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/image"
+)
+
+func TestFileJobSnapshot(t *testing.T) {
+	ctx := context.Background()
+	snapshot := image.NewFileJobSnapshot(filepath.Join(t.TempDir(), "jobs.json"))
+
+	if jobs, err := snapshot.Load(ctx); err != nil || len(jobs) != 0 {
+		t.Errorf("expected no saved jobs, got: %v, %v", jobs, err)
+	}
+
+	saved := []*image.ResizeJob{
+		{BatchID: "batch", URL: "https://example.com/a.jpg", Priority: "high", ImageIndexes: []int{0}, Attempt: 1},
+		{BatchID: "batch", URL: "https://example.com/b.jpg", ImageIndexes: []int{1}},
+	}
+	if err := snapshot.Save(ctx, saved); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	jobs, err := snapshot.Load(ctx)
+	if err != nil || len(jobs) != 2 || jobs[0].URL != saved[0].URL || jobs[0].Priority != "high" || jobs[0].Attempt != 1 {
+		t.Errorf("unexpected jobs: %v, %v", jobs, err)
+	}
+
+	// loaded jobs are removed from the snapshot
+	if jobs, err := snapshot.Load(ctx); err != nil || len(jobs) != 0 {
+		t.Errorf("expected no saved jobs, got: %v, %v", jobs, err)
+	}
+}
+
+func TestFileJobSnapshotDisabled(t *testing.T) {
+	snapshot := image.NewFileJobSnapshot("")
+
+	if err := snapshot.Save(context.Background(), []*image.ResizeJob{{URL: "https://example.com/a.jpg"}}); !errors.Is(err, image.ErrSnapshotDisabled) {
+		t.Errorf("expected snapshot disabled error, got: %v", err)
+	}
+}
@@ -0,0 +1,702 @@
+package image_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/jobs"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/webhook"
+)
+
+func TestResizerTracksAsyncJobs(t *testing.T) {
+	data := encodeTestPNG(t, 40, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+
+	// the second url is the same as the first, so it's left to the first job
+	urls := []string{server.URL + "/a.png", server.URL + "/a.png", server.URL + "/missing.png"}
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: urls, Transformation: model.Transformation{Width: 10}})
+
+	batchID := responses[0].BatchID
+	if batchID == "" || responses[2].BatchID != batchID {
+		t.Fatalf("expected responses to share a batch id, got: %v", responses)
+	}
+
+	job, ok := resizer.Jobs().Get(context.Background(), batchID)
+	if !ok || job.State != model.JobStateQueued || len(job.Images) != 3 {
+		t.Fatalf("unexpected job: %v", job)
+	}
+
+	resizer.Start()
+	resizer.Shutdown()
+
+	// wait for the image resized by the other job to be recorded
+	for job.Images[1].State == model.JobStateQueued {
+		job, _ = resizer.Jobs().Get(context.Background(), batchID)
+	}
+
+	if job.State != model.JobStateFailed || job.FinishedAt == nil {
+		t.Errorf("unexpected job state: %s", job.State)
+	}
+
+	for i, state := range []string{model.JobStateSucceeded, model.JobStateSucceeded, model.JobStateFailed} {
+		if job.Images[i].State != state {
+			t.Errorf("unexpected image %d state: %s", i, job.Images[i].State)
+		}
+	}
+
+	if job.Images[0].Attempts != 1 || job.Images[0].StartedAt == nil || job.Images[1].Attempts != 0 {
+		t.Errorf("unexpected attempts: %v", job.Images)
+	}
+
+	if job.Images[2].ErrorCode != model.ErrorCodeOriginStatus || job.Images[2].OriginStatus != http.StatusNotFound {
+		t.Errorf("unexpected failed image: %v", job.Images[2])
+	}
+}
+
+func TestResizerDeliversJobCallback(t *testing.T) {
+	server := buildImageServer(t, 40, 20)
+	defer server.Close()
+
+	payloads := make(chan model.JobCallbackPayload, 1)
+	callbackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhook.Verify("secret", r.Header, body, time.Now()) {
+			t.Errorf("unexpected signature: %s", r.Header.Get(webhook.SignatureHeader))
+		}
+
+		payload := model.JobCallbackPayload{}
+		_ = json.Unmarshal(body, &payload)
+		payloads <- payload
+	}))
+	defer callbackServer.Close()
+
+	s := buildSettings()
+	s.Service.WebhookSecret = "secret"
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+
+	request := &model.ResizeRequest{URLs: []string{server.URL}, CallbackURL: callbackServer.URL}
+	responses := resizer.ProcessAsync(request)
+	resizer.Start()
+	resizer.Shutdown()
+
+	payload := <-payloads
+	if payload.BatchID != responses[0].BatchID || payload.State != model.JobStateSucceeded || len(payload.Images) != 1 {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+
+	// wait for the delivery to be recorded
+	deadline := time.Now().Add(5 * time.Second)
+	job, _ := resizer.Jobs().Get(context.Background(), payload.BatchID)
+	for job.Callback.State == model.CallbackStatePending {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the callback delivery to be recorded")
+		}
+		time.Sleep(time.Millisecond)
+		job, _ = resizer.Jobs().Get(context.Background(), payload.BatchID)
+	}
+
+	if job.Callback.State != model.CallbackStateDelivered || len(job.Callback.Attempts) != 1 || job.Callback.Attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("unexpected callback: %v", job.Callback)
+	}
+}
+
+func TestResizerShutdownAbortsCallbacksAfterDrainTimeout(t *testing.T) {
+	server := buildImageServer(t, 40, 20)
+	defer server.Close()
+
+	release := make(chan struct{})
+	callbackServer := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer callbackServer.Close()
+	defer close(release)
+
+	s := buildSettings()
+	s.Service.WebhookSecret = "secret"
+	s.Service.ShutdownDrainTimeout = 200 * time.Millisecond
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+
+	request := &model.ResizeRequest{URLs: []string{server.URL}, CallbackURL: callbackServer.URL}
+	responses := resizer.ProcessAsync(request)
+	resizer.Start()
+
+	start := time.Now()
+	resizer.Shutdown()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the shutdown to be bounded by the drain timeout, took %v", elapsed)
+	}
+
+	// the shutdown returns once the aborted delivery is recorded
+	job, _ := resizer.Jobs().Get(context.Background(), responses[0].BatchID)
+	if job.Callback.State != model.CallbackStateFailed {
+		t.Errorf("unexpected callback: %v", job.Callback)
+	}
+}
+
+func TestResizerRetriesJobsIntoDeadLetters(t *testing.T) {
+	data := encodeTestPNG(t, 40, 20)
+	var requests, healthy atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/missing.png":
+			w.WriteHeader(http.StatusNotFound)
+		case healthy.Load() == 0:
+			requests.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.RetryMaxAttempts = 2
+	s.Service.RetryBackoff = time.Millisecond
+	s.Service.RetryMaxBackoff = 10 * time.Millisecond
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+	ctx := context.Background()
+
+	// the missing image fails for good, and isn't retried
+	urls := []string{server.URL + "/a.png", server.URL + "/missing.png"}
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: urls})
+	batchID := responses[0].BatchID
+	resizer.Start()
+	defer resizer.Shutdown()
+
+	letters := waitForDeadLetters(t, resizer, 1)
+	if letters[0].Job.URL != urls[0] || letters[0].Attempts != 2 || letters[0].ErrorCode != model.ErrorCodeOriginStatus {
+		t.Errorf("unexpected dead letter: %v", letters[0])
+	}
+
+	if requests.Load() != 2 {
+		t.Errorf("expected 2 attempts, got: %d", requests.Load())
+	}
+
+	job, _ := resizer.Jobs().Get(ctx, batchID)
+	if job.State != model.JobStateFailed || job.Images[0].Attempts != 2 || job.Images[1].Attempts != 1 {
+		t.Errorf("unexpected job: %v", job)
+	}
+
+	healthy.Store(1)
+	if err := resizer.RequeueDeadLetter(ctx, letters[0].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := resizer.RequeueDeadLetter(ctx, letters[0].ID); !errors.Is(err, image.ErrDeadLetterNotFound) {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+
+	for job.Images[0].State != model.JobStateSucceeded {
+		time.Sleep(time.Millisecond)
+		job, _ = resizer.Jobs().Get(ctx, batchID)
+	}
+
+	if job.Images[0].Attempts != 1 || job.Images[0].RetryAt != nil {
+		t.Errorf("unexpected requeued image: %v", job.Images[0])
+	}
+}
+
+func TestResizerRequeuedDeadLettersFollowImagesInProgress(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.RetryMaxAttempts = 1
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+	ctx := context.Background()
+
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: []string{server.URL + "/a.png"}})
+	batchID, imageID := responses[0].BatchID, responses[0].ID
+	resizer.Start()
+	defer resizer.Shutdown()
+
+	letters := waitForDeadLetters(t, resizer, 1)
+
+	// another request resizes the image meanwhile, so the requeued job
+	// follows it rather than taking its lock
+	if resizer.ResizingProgress().CheckAndSetResizing(ctx, imageID, "other") {
+		t.Fatal("expected image not to be resizing")
+	}
+	if err := resizer.RequeueDeadLetter(ctx, letters[0].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	job, _ := resizer.Jobs().Get(ctx, batchID)
+	if !job.Images[0].Followed || job.Images[0].State != model.JobStateQueued {
+		t.Errorf("unexpected requeued image: %v", job.Images[0])
+	}
+
+	imageCache.Add(ctx, imageID, []byte("resized"))
+	resizer.ResizingProgress().DeleteResizing(ctx, imageID, "other")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Images[0].State != model.JobStateSucceeded {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the followed image to succeed, got: %v", job.Images[0])
+		}
+		time.Sleep(time.Millisecond)
+		job, _ = resizer.Jobs().Get(ctx, batchID)
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("expected the image not to be fetched again, got %d fetches", requests.Load())
+	}
+}
+
+// Waits for the resizer to have the given number of dead letters.
+func waitForDeadLetters(t *testing.T, resizer *image.Resizer, count int) []*image.DeadLetter {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		letters, _ := resizer.DeadLetters().List(context.Background(), 0, count+1)
+		if len(letters) >= count {
+			return letters
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("expected %d dead letters", count)
+	return nil
+}
+
+func TestResizerSetWorkers(t *testing.T) {
+	server := buildImageServer(t, 40, 20)
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.ResizeWorkers = 2
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+
+	resizer.Start()
+	if resizer.Workers() != 2 {
+		t.Errorf("expected 2 workers, got: %d", resizer.Workers())
+	}
+
+	for _, n := range []int{5, 1} {
+		if err := resizer.SetWorkers(n); err != nil || resizer.Workers() != n {
+			t.Errorf("expected %d workers, got: %d, %v", n, resizer.Workers(), err)
+		}
+	}
+
+	if err := resizer.SetWorkers(0); err == nil {
+		t.Error("expected an empty pool to be rejected")
+	}
+
+	// the remaining worker still runs jobs
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: []string{server.URL}, Transformation: model.Transformation{Width: 10}})
+	job, _ := resizer.Jobs().Get(context.Background(), responses[0].BatchID)
+	for job.FinishedAt == nil {
+		time.Sleep(time.Millisecond)
+		job, _ = resizer.Jobs().Get(context.Background(), responses[0].BatchID)
+	}
+
+	if job.State != model.JobStateSucceeded {
+		t.Errorf("unexpected job state: %s", job.State)
+	}
+
+	resizer.Shutdown()
+	if err := resizer.SetWorkers(2); !errors.Is(err, image.ErrResizerStopped) {
+		t.Errorf("expected resizer stopped error, got: %v", err)
+	}
+}
+
+func TestResizerExtendsLongRunningJobs(t *testing.T) {
+	data := encodeTestPNG(t, 40, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.QueueVisibilityTimeout = 30 * time.Millisecond
+	queue := &visibilityQueue{JobQueue: image.NewMemoryJobQueue(s), timeout: s.Service.QueueVisibilityTimeout, touched: map[*image.ResizeJob]time.Time{}}
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizerWithBackends(s, imageCache, image.ResizerBackends{
+		Queue:            queue,
+		Jobs:             jobs.NewMemoryJobStore(0),
+		ResizingProgress: image.NewLocalResizingProgress(s),
+		DeadLetters:      image.NewMemoryDeadLetterStore(),
+		Snapshots:        image.NewFileJobSnapshot(filepath.Join(t.TempDir(), "jobs.json")),
+		SourceIndex:      cache.NewMemorySourceIndex(10),
+	})
+
+	resizer.ProcessAsync(&model.ResizeRequest{URLs: []string{server.URL + "/a.png"}})
+	resizer.Start()
+	resizer.Shutdown()
+
+	// the job runs five times as long as the visibility timeout, but it's
+	// never left idle long enough to be reclaimed
+	if queue.acked.Load() != 1 || queue.extended.Load() == 0 {
+		t.Errorf("expected the job to be extended until acknowledged, got %d extensions, %d acks", queue.extended.Load(), queue.acked.Load())
+	}
+	if n := queue.stalled.Load(); n != 0 {
+		t.Errorf("expected the job not to stall, got %d stalls", n)
+	}
+}
+
+// A job queue that counts the received jobs that were left idle for longer
+// than the visibility timeout, which a durable queue would hand out again.
+type visibilityQueue struct {
+	image.JobQueue
+	timeout  time.Duration
+	touched  map[*image.ResizeJob]time.Time
+	mu       sync.Mutex
+	extended atomic.Int32
+	acked    atomic.Int32
+	stalled  atomic.Int32
+}
+
+func (q *visibilityQueue) Receive(ctx context.Context) (*image.ResizeJob, error) {
+	job, err := q.JobQueue.Receive(ctx)
+	if err == nil {
+		q.touch(job)
+	}
+	return job, err
+}
+
+func (q *visibilityQueue) Extend(ctx context.Context, job *image.ResizeJob) error {
+	q.extended.Add(1)
+	q.touch(job)
+	return q.JobQueue.Extend(ctx, job)
+}
+
+func (q *visibilityQueue) Ack(ctx context.Context, job *image.ResizeJob) error {
+	q.acked.Add(1)
+	q.touch(job)
+	return q.JobQueue.Ack(ctx, job)
+}
+
+func (q *visibilityQueue) touch(job *image.ResizeJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if touched, ok := q.touched[job]; ok && time.Since(touched) > q.timeout {
+		q.stalled.Add(1)
+	}
+	q.touched[job] = time.Now()
+}
+
+func TestResizerRejectsJobsOfFullQueue(t *testing.T) {
+	server := buildImageServer(t, 40, 20)
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.ResizeQueueSize = 1
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+
+	urls := []string{server.URL + "/a.png", server.URL + "/b.png"}
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: urls})
+
+	if responses[0].Result != "enqueued" || responses[0].RetryAfter != 0 {
+		t.Errorf("unexpected response: %v", responses[0])
+	}
+
+	// with no jobs finished yet, a worker is taken to finish one a second
+	if responses[1].ErrorCode != model.ErrorCodeQueueFull || responses[1].RetryAfter != 1 || responses[1].ID == "" {
+		t.Errorf("unexpected response: %v", responses[1])
+	}
+
+	job, _ := resizer.Jobs().Get(context.Background(), responses[0].BatchID)
+	if job.Images[1].State != model.JobStateFailed || job.Images[1].ErrorCode != model.ErrorCodeQueueFull {
+		t.Errorf("unexpected job image: %v", job.Images[1])
+	}
+}
+
+func TestResizerCancelsJobs(t *testing.T) {
+	started, aborted := make(chan struct{}), make(chan struct{})
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slow.png" {
+			requests.Add(1)
+			return
+		}
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.ResizeWorkers = 1
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+	ctx := context.Background()
+
+	// the single worker runs the slow job, while the other one waits
+	urls := []string{server.URL + "/slow.png", server.URL + "/a.png"}
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: urls})
+	batchID := responses[0].BatchID
+	resizer.Start()
+	<-started
+
+	job, err := resizer.CancelJob(ctx, batchID)
+	if err != nil || job.State != model.JobStateCancelled {
+		t.Fatalf("expected job to be cancelled, got: %v, %v", job, err)
+	}
+
+	resizer.Shutdown()
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Error("expected fetch of the running job to be aborted")
+	}
+
+	if requests.Load() != 0 {
+		t.Errorf("expected queued job to be skipped, got %d requests", requests.Load())
+	}
+
+	job, _ = resizer.Jobs().Get(ctx, batchID)
+	for i := range job.Images {
+		if job.Images[i].State != model.JobStateCancelled {
+			t.Errorf("unexpected image %d state: %s", i, job.Images[i].State)
+		}
+	}
+
+	// cancelled jobs aren't retried
+	if letters, _ := resizer.DeadLetters().List(ctx, 0, 1); len(letters) != 0 {
+		t.Errorf("unexpected dead letters: %v", letters)
+	}
+
+	if _, err := resizer.CancelJob(ctx, batchID); !errors.Is(err, image.ErrJobFinished) {
+		t.Errorf("expected job finished error, got: %v", err)
+	}
+
+	if _, err := resizer.CancelJob(ctx, "unknown"); !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected job not found error, got: %v", err)
+	}
+}
+
+func TestResizerTakesOverResizesCancelledByAnotherBatch(t *testing.T) {
+	data := encodeTestPNG(t, 40, 20)
+	started := make(chan struct{})
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first fetch hangs until it's aborted
+		if requests.Add(1) == 1 {
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.ResizeWorkers = 1
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+	ctx := context.Background()
+	resizer.Start()
+	defer resizer.Shutdown()
+
+	request := &model.ResizeRequest{URLs: []string{server.URL + "/a.png"}}
+	first := resizer.ProcessAsync(request)
+	<-started
+
+	// the second batch follows the resize run by the first one
+	second := resizer.ProcessAsync(request)
+	job, _ := resizer.Jobs().Get(ctx, second[0].BatchID)
+	if !job.Images[0].Followed {
+		t.Fatalf("expected image to be followed, got: %v", job.Images[0])
+	}
+
+	if _, err := resizer.CancelJob(ctx, first[0].BatchID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !job.Images[0].Done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the second batch to resize the image, got: %v", job.Images[0])
+		}
+		time.Sleep(time.Millisecond)
+		job, _ = resizer.Jobs().Get(ctx, second[0].BatchID)
+	}
+
+	if job.Images[0].State != model.JobStateSucceeded || !imageCache.Contains(ctx, second[0].ID) {
+		t.Errorf("unexpected image of the second batch: %v", job.Images[0])
+	}
+
+	job, _ = resizer.Jobs().Get(ctx, first[0].BatchID)
+	if job.Images[0].State != model.JobStateCancelled {
+		t.Errorf("unexpected image of the first batch: %v", job.Images[0])
+	}
+}
+
+func TestResizerSavesUnprocessedJobs(t *testing.T) {
+	data := encodeTestPNG(t, 40, 20)
+	started := make(chan struct{}, 1)
+	var healthy atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() == 0 {
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.ResizeWorkers = 1
+	s.Service.ShutdownDrainTimeout = 50 * time.Millisecond
+	s.Service.JobSnapshotPath = filepath.Join(t.TempDir(), "jobs.json")
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(s, imageCache)
+	ctx := context.Background()
+
+	// the single worker is stuck on the first job, while the other one waits
+	urls := []string{server.URL + "/a.png", server.URL + "/b.png"}
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: urls})
+	resizer.Start()
+	<-started
+	resizer.Shutdown()
+
+	job, _ := resizer.Jobs().Get(ctx, responses[0].BatchID)
+	for i := range job.Images {
+		if job.Images[i].State != model.JobStateQueued || job.Images[i].Attempts != 0 {
+			t.Errorf("unexpected image %d: %v", i, job.Images[i])
+		}
+	}
+
+	// the saved jobs are run once a resizer is started again
+	healthy.Store(1)
+	imageCache, _ = cache.NewLRUImageCache(10)
+	resizer = image.NewResizer(s, imageCache)
+	resizer.Start()
+	defer resizer.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !imageCache.Contains(ctx, responses[0].ID) || !imageCache.Contains(ctx, responses[1].ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected saved jobs to be run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResizerRestoredJobsFollowImagesInProgress(t *testing.T) {
+	data := encodeTestPNG(t, 40, 20)
+	started := make(chan struct{}, 1)
+	var healthy, fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() == 0 {
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		fetches.Add(1)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.ResizeWorkers = 1
+	s.Service.ShutdownDrainTimeout = 50 * time.Millisecond
+	s.Service.JobSnapshotPath = filepath.Join(t.TempDir(), "jobs.json")
+	ctx := context.Background()
+
+	// the job records and the images in progress outlive the resizers, as
+	// they do in Redis
+	jobStore := jobs.NewMemoryJobStore(0)
+	progress := image.NewLocalResizingProgress(s)
+	newResizer := func(imageCache cache.ImageCacheAdapter) *image.Resizer {
+		return image.NewResizerWithBackends(s, imageCache, image.ResizerBackends{
+			Queue:            image.NewMemoryJobQueue(s),
+			Jobs:             jobStore,
+			ResizingProgress: progress,
+			DeadLetters:      image.NewMemoryDeadLetterStore(),
+			Snapshots:        image.NewFileJobSnapshot(s.Service.JobSnapshotPath),
+			SourceIndex:      cache.NewMemorySourceIndex(10),
+		})
+	}
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := newResizer(imageCache)
+	urls := []string{server.URL + "/a.png", server.URL + "/b.png"}
+	responses := resizer.ProcessAsync(&model.ResizeRequest{URLs: urls})
+	batchID := responses[0].BatchID
+	resizer.Start()
+	<-started
+	resizer.Shutdown()
+
+	// another batch resizes the second image meanwhile, which the restored
+	// job follows, while it takes back the first one
+	progress.CancelResizing(ctx, responses[1].ID, batchID)
+	if progress.CheckAndSetResizing(ctx, responses[1].ID, "other") {
+		t.Fatal("expected image not to be resizing")
+	}
+
+	healthy.Store(1)
+	imageCache, _ = cache.NewLRUImageCache(10)
+	resizer = newResizer(imageCache)
+	resizer.Start()
+	defer resizer.Shutdown()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !imageCache.Contains(ctx, responses[0].ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the restored job to be run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	job, _ := resizer.Jobs().Get(ctx, batchID)
+	if job.Images[0].Followed || !job.Images[1].Followed {
+		t.Errorf("unexpected restored images: %v", job.Images)
+	}
+
+	imageCache.Add(ctx, responses[1].ID, []byte("resized"))
+	progress.DeleteResizing(ctx, responses[1].ID, "other")
+
+	for job.State != model.JobStateSucceeded {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the restored job to succeed, got: %v", job.Images)
+		}
+		time.Sleep(time.Millisecond)
+		job, _ = resizer.Jobs().Get(ctx, batchID)
+	}
+
+	if fetches.Load() != 1 {
+		t.Errorf("expected only the first image to be fetched, got %d fetches", fetches.Load())
+	}
+}
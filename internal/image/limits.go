@@ -0,0 +1,68 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	goimage "image"
+
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+// Returned when a source image exceeds the configured size limits.
+var ErrImageTooLarge = errors.New("image too large")
+
+// Checks the dimensions of a source image, as read from its header, before
+// it gets decoded, so that small files declaring huge dimensions can't
+// exhaust memory. Zero limits are not enforced.
+func checkSourceLimits(settings *settings.Settings, config goimage.Config) error {
+	service := settings.Service
+	if service.MaxImageWidth > 0 && config.Width > service.MaxImageWidth {
+		return fmt.Errorf("%w: width %d exceeds %d pixels", ErrImageTooLarge, config.Width, service.MaxImageWidth)
+	}
+	if service.MaxImageHeight > 0 && config.Height > service.MaxImageHeight {
+		return fmt.Errorf("%w: height %d exceeds %d pixels", ErrImageTooLarge, config.Height, service.MaxImageHeight)
+	}
+
+	megapixels := float64(config.Width) * float64(config.Height) / 1e6
+	if service.MaxImageMegapixels > 0 && megapixels > service.MaxImageMegapixels {
+		return fmt.Errorf("%w: %.1f megapixels exceed %.1f", ErrImageTooLarge, megapixels, service.MaxImageMegapixels)
+	}
+
+	return nil
+}
+
+// Checks the requested dimensions against the configured upper bounds.
+func checkTargetLimits(settings *settings.Settings, t *model.Transformation) error {
+	service := settings.Service
+	if service.MaxTargetWidth > 0 && t.Width > service.MaxTargetWidth {
+		return fmt.Errorf("width must not exceed %d", service.MaxTargetWidth)
+	}
+	if service.MaxTargetHeight > 0 && t.Height > service.MaxTargetHeight {
+		return fmt.Errorf("height must not exceed %d", service.MaxTargetHeight)
+	}
+
+	return nil
+}
+
+// Checks the dimensions an image is about to be resized to, as computed from
+// its source dimensions, against the upper bounds of the requested ones, and
+// against the megapixels a source may have. Requested dimensions within the
+// bounds may still scale a tall and narrow image, or a short and wide one,
+// far beyond them.
+func checkResizeLimits(settings *settings.Settings, width uint, height uint) error {
+	service := settings.Service
+	if service.MaxTargetWidth > 0 && width > service.MaxTargetWidth {
+		return fmt.Errorf("%w: resized width %d exceeds %d pixels", ErrImageTooLarge, width, service.MaxTargetWidth)
+	}
+	if service.MaxTargetHeight > 0 && height > service.MaxTargetHeight {
+		return fmt.Errorf("%w: resized height %d exceeds %d pixels", ErrImageTooLarge, height, service.MaxTargetHeight)
+	}
+
+	megapixels := float64(width) * float64(height) / 1e6
+	if service.MaxImageMegapixels > 0 && megapixels > service.MaxImageMegapixels {
+		return fmt.Errorf("%w: resized to %.1f megapixels, exceeding %.1f", ErrImageTooLarge, megapixels, service.MaxImageMegapixels)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package image_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+func TestResizerRejectsLargeSources(t *testing.T) {
+	server := buildImageServer(t, 400, 200)
+	defer server.Close()
+
+	tests := []func(*settings.ServiceSettings){
+		func(s *settings.ServiceSettings) { s.MaxImageWidth = 399 },
+		func(s *settings.ServiceSettings) { s.MaxImageHeight = 199 },
+		func(s *settings.ServiceSettings) { s.MaxImageMegapixels = 0.07 },
+		func(s *settings.ServiceSettings) { s.MaxImageSize = 100 },
+	}
+
+	for i, limit := range tests {
+		settings := buildSettings()
+		limit(settings.Service)
+		imageCache, _ := cache.NewLRUImageCache(10)
+		resizer := image.NewResizer(settings, imageCache)
+
+		_, err := resizer.Transform(context.Background(), server.URL, model.Transformation{Width: 100})
+		if !errors.Is(err, image.ErrImageTooLarge) {
+			t.Errorf("expected limit %d to reject the image, got: %v", i, err)
+		}
+	}
+}
+
+func TestResizerAcceptsSourcesWithinLimits(t *testing.T) {
+	server := buildImageServer(t, 400, 200)
+	defer server.Close()
+
+	settings := buildSettings()
+	settings.Service.MaxImageWidth = 400
+	settings.Service.MaxImageHeight = 200
+	settings.Service.MaxImageMegapixels = 0.08
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(settings, imageCache)
+
+	if _, err := resizer.Transform(context.Background(), server.URL, model.Transformation{Width: 100}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestResizerRejectsOversizedResizes(t *testing.T) {
+	server := buildImageServer(t, 1, 2000)
+	defer server.Close()
+
+	tests := []model.Transformation{
+		{Width: 100},
+		{Width: 100, Height: 100, Fit: "cover"},
+		{Width: 100, Height: 100, Fit: "outside"},
+	}
+
+	settings := buildSettings()
+	settings.Service.MaxTargetWidth = 4096
+	settings.Service.MaxTargetHeight = 4096
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(settings, imageCache)
+
+	for _, tr := range tests {
+		_, err := resizer.Transform(context.Background(), server.URL, tr)
+		if !errors.Is(err, image.ErrImageTooLarge) {
+			t.Errorf("expected %+v to be rejected, got: %v", tr, err)
+		}
+	}
+
+	if _, err := resizer.Transform(context.Background(), server.URL, model.Transformation{Width: 2}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
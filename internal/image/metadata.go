@@ -0,0 +1,120 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Metadata options. By default all metadata is stripped from resized images.
+const (
+	metadataStrip = "strip"
+	metadataKeep  = "keep"
+)
+
+var (
+	metadataOptions = []string{metadataStrip, metadataKeep}
+
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+	markerAPP2 = 0xe2
+)
+
+// jpegSegment is a JPEG marker segment. Data holds the whole segment,
+// including the marker and the length field.
+type jpegSegment struct {
+	Marker byte
+	Data   []byte
+}
+
+// Returns the payload of the segment, following the length field.
+func (s jpegSegment) payload() []byte {
+	return s.Data[4:]
+}
+
+// Returns the marker segments of the JPEG data that precede the image scan.
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil
+	}
+
+	segments := []jpegSegment{}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			break
+		}
+
+		marker := data[pos+1]
+		switch {
+		case marker == 0xff:
+			// fill byte
+			pos++
+			continue
+		case marker == markerSOS || marker == markerEOI:
+			return segments
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// standalone markers carry no length
+			pos += 2
+			continue
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) || end < pos+4 {
+			break
+		}
+		segments = append(segments, jpegSegment{Marker: marker, Data: data[pos:end]})
+		pos = end
+	}
+
+	return segments
+}
+
+// Returns copies of the EXIF, XMP and ICC profile segments of the JPEG data.
+// If resetOrientation is set, the EXIF orientation of the copy is set to
+// normal, for images that have already been rotated accordingly.
+func extractMetadata(data []byte, resetOrientation bool) [][]byte {
+	metadata := [][]byte{}
+	for _, segment := range jpegSegments(data) {
+		payload := segment.payload()
+		switch {
+		case segment.Marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader):
+			exif := bytes.Clone(segment.Data)
+			if resetOrientation {
+				setExifOrientation(exif[4+len(exifHeader):], 1)
+			}
+			metadata = append(metadata, exif)
+		case segment.Marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader),
+			segment.Marker == markerAPP2 && bytes.HasPrefix(payload, iccHeader):
+			metadata = append(metadata, bytes.Clone(segment.Data))
+		}
+	}
+
+	return metadata
+}
+
+// Inserts the given marker segments right after the start of the JPEG data.
+func injectSegments(data []byte, segments [][]byte) []byte {
+	if len(segments) == 0 || len(data) < 2 {
+		return data
+	}
+
+	size := len(data)
+	for _, segment := range segments {
+		size += len(segment)
+	}
+
+	result := make([]byte, 0, size)
+	result = append(result, data[:2]...)
+	for _, segment := range segments {
+		result = append(result, segment...)
+	}
+
+	return append(result, data[2:]...)
+}
@@ -0,0 +1,163 @@
+package image
+
+import (
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+// Priorities of async resize jobs, each queued in a lane of its own. Jobs
+// without a priority are normal.
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityBulk   = "bulk"
+
+	// What happens to a job enqueued into a full lane: it's either rejected
+	// right away, or it waits a while for room in the lane.
+	fullPolicyReject = "reject"
+	fullPolicyWait   = "wait"
+)
+
+var (
+	priorities   = []string{priorityHigh, priorityNormal, priorityBulk}
+	fullPolicies = []string{fullPolicyReject, fullPolicyWait}
+)
+
+// laneConfig configures the lane of a job queue that holds the jobs of a
+// priority.
+type laneConfig struct {
+	priority   string
+	size       int
+	weight     int
+	fullPolicy string
+}
+
+// Returns the configuration of the lanes of a job queue, from the highest
+// priority down, in the order of laneIndex.
+func laneConfigs(settings *settings.Settings) []laneConfig {
+	s := settings.Service
+	sizes := laneSizes([]int{s.QueueHighSize, s.QueueNormalSize, s.QueueBulkSize}, resizeQueueSize(settings))
+	return []laneConfig{
+		newLaneConfig(priorityHigh, sizes[0], s.QueueHighWeight, s.QueueHighFullPolicy),
+		newLaneConfig(priorityNormal, sizes[1], s.QueueNormalWeight, s.QueueNormalFullPolicy),
+		newLaneConfig(priorityBulk, sizes[2], s.QueueBulkWeight, s.QueueBulkFullPolicy),
+	}
+}
+
+// Returns the sizes of the lanes, so that they add up to the size of the
+// whole queue. The lanes without a size of their own split evenly what the
+// others leave of it, each getting room for a job at least.
+func laneSizes(sizes []int, queueSize int) []int {
+	sizes = slices.Clone(sizes)
+	unsized := 0
+	for _, size := range sizes {
+		if size > 0 {
+			queueSize -= size
+		} else {
+			unsized++
+		}
+	}
+	if unsized == 0 {
+		return sizes
+	}
+
+	queueSize = max(0, queueSize)
+	share, rest := queueSize/unsized, queueSize%unsized
+	for i := range sizes {
+		if sizes[i] > 0 {
+			continue
+		}
+		sizes[i] = share
+		if rest > 0 {
+			sizes[i]++
+			rest--
+		}
+		sizes[i] = max(1, sizes[i])
+	}
+
+	return sizes
+}
+
+func newLaneConfig(priority string, size int, weight int, fullPolicy string) laneConfig {
+	if !slices.Contains(fullPolicies, fullPolicy) {
+		if fullPolicy != "" {
+			log.Printf("unknown queue full policy %s of %s lane, rejecting jobs instead", fullPolicy, priority)
+		}
+		fullPolicy = fullPolicyReject
+	}
+
+	return laneConfig{priority: priority, size: size, weight: max(1, weight), fullPolicy: fullPolicy}
+}
+
+// Returns the index of the lane that holds jobs of the priority.
+func laneIndex(priority string) int {
+	switch priority {
+	case priorityHigh:
+		return 0
+	case priorityBulk:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// laneScheduler picks the lane the next job is taken from by smooth weighted
+// round-robin. Each lane that has jobs gets a share of the picks in
+// proportion to its weight, so a busy lane never starves the others.
+type laneScheduler struct {
+	weights []int
+	current []int
+	mu      sync.Mutex
+}
+
+func newLaneScheduler(lanes []laneConfig) *laneScheduler {
+	s := &laneScheduler{weights: make([]int, len(lanes)), current: make([]int, len(lanes))}
+	for i, lane := range lanes {
+		s.weights[i] = lane.weight
+	}
+
+	return s
+}
+
+// Picks the lane to take the next job from, among the lanes that have jobs.
+// Returns -1 if none has.
+func (s *laneScheduler) next(hasJobs func(lane int) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	picked, total := -1, 0
+	for i, weight := range s.weights {
+		if !hasJobs(i) {
+			continue
+		}
+		s.current[i] += weight
+		total += weight
+		if picked < 0 || s.current[i] > s.current[picked] {
+			picked = i
+		}
+	}
+	if picked >= 0 {
+		s.current[picked] -= total
+	}
+
+	return picked
+}
+
+// Returns all lanes, the one picked for the next job first, followed by the
+// others from the highest priority down. Meant for queues that can't tell
+// which lanes have jobs without looking.
+func (s *laneScheduler) order() []int {
+	picked := s.next(func(int) bool { return true })
+
+	order := []int{picked}
+	for i := range s.weights {
+		if i != picked {
+			order = append(order, i)
+		}
+	}
+
+	return order
+}
@@ -0,0 +1,35 @@
+package image
+
+import (
+	"context"
+	"log"
+)
+
+// Removes every cached image derived from the source image at url, whatever
+// its transformation. Images being resized from it at the time are cached
+// once they're done, so they may have to be purged again. Returns how many
+// images were removed.
+func (r *Resizer) PurgeSource(ctx context.Context, url string) (int, error) {
+	imageIDs, err := r.sourceIndex.Get(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, imageID := range imageIDs {
+		deleted, err := r.imageCache.Delete(ctx, imageID)
+		if err != nil {
+			return purged, err
+		}
+		if deleted {
+			log.Print("purged ", imageID)
+			purged++
+		}
+	}
+
+	if err := r.sourceIndex.Delete(ctx, url); err != nil {
+		return purged, err
+	}
+
+	return purged, nil
+}
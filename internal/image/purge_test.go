@@ -0,0 +1,57 @@
+package image_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+func TestResizerPurgeSource(t *testing.T) {
+	purged := buildImageServer(t, 40, 20)
+	defer purged.Close()
+	kept := buildImageServer(t, 40, 20)
+	defer kept.Close()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+	ctx := context.Background()
+
+	request := &model.ResizeRequest{
+		URLs:           []string{purged.URL, kept.URL},
+		Transformation: model.Transformation{Format: "png"},
+		Variants:       []model.Transformation{{Width: 10}, {Width: 20}},
+	}
+	responses, err := resizer.Process(request, ctx)
+	if err != nil || len(responses) != 2 {
+		t.Fatalf("unexpected responses: %v, %v", responses, err)
+	}
+
+	// an image transformed on its own is purged too
+	transformation := model.Transformation{Width: 30, Format: "png"}
+	if _, err := resizer.Transform(ctx, purged.URL, transformation); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n, err := resizer.PurgeSource(ctx, purged.URL)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 images to be purged, got: %d, %v", n, err)
+	}
+
+	for _, variant := range responses[0].Variants {
+		if imageCache.Contains(ctx, variant.ID) {
+			t.Errorf("expected %s to be purged", variant.ID)
+		}
+	}
+	for _, variant := range responses[1].Variants {
+		if !imageCache.Contains(ctx, variant.ID) {
+			t.Errorf("expected %s to be kept", variant.ID)
+		}
+	}
+
+	if n, err := resizer.PurgeSource(ctx, purged.URL); err != nil || n != 0 {
+		t.Errorf("expected nothing left to purge, got: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,104 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	deadLettersKey      = "img-resize:dead-letters"
+	deadLettersIndexKey = "img-resize:dead-letters:index"
+)
+
+// RedisDeadLetterStore keeps dead letters in a Redis hash, indexed by the
+// time they failed in a sorted set, so that they're shared by all instances.
+type RedisDeadLetterStore struct {
+	client *redis.Client
+}
+
+func NewRedisDeadLetterStore(client *redis.Client) DeadLetterStoreAdapter {
+	return &RedisDeadLetterStore{client: client}
+}
+
+func (store *RedisDeadLetterStore) Add(ctx context.Context, letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, deadLettersKey, letter.ID, data)
+		pipe.ZAdd(ctx, deadLettersIndexKey, redis.Z{Score: float64(letter.FailedAt.UnixMilli()), Member: letter.ID})
+		return nil
+	})
+	return err
+}
+
+func (store *RedisDeadLetterStore) List(ctx context.Context, offset int, limit int) ([]*DeadLetter, error) {
+	if limit <= 0 {
+		return []*DeadLetter{}, nil
+	}
+
+	ids, err := store.client.ZRevRange(ctx, deadLettersIndexKey, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil || len(ids) == 0 {
+		return []*DeadLetter{}, err
+	}
+
+	values, err := store.client.HMGet(ctx, deadLettersKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		letter := &DeadLetter{}
+		if err := json.Unmarshal([]byte(data), letter); err != nil {
+			log.Printf("error decoding dead letter %s: %v", ids[i], err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+func (store *RedisDeadLetterStore) Get(ctx context.Context, id string) (*DeadLetter, bool) {
+	data, err := store.client.HGet(ctx, deadLettersKey, id).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("error reading dead letter %s: %v", id, err)
+		}
+		return nil, false
+	}
+
+	letter := &DeadLetter{}
+	if err := json.Unmarshal(data, letter); err != nil {
+		log.Printf("error decoding dead letter %s: %v", id, err)
+		return nil, false
+	}
+
+	return letter, true
+}
+
+// Only one of concurrent deletes of a dead letter succeeds, so that a dead
+// letter requeued by two admins at once is requeued only once.
+func (store *RedisDeadLetterStore) Delete(ctx context.Context, id string) error {
+	deleted, err := store.client.HDel(ctx, deadLettersKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	return store.client.ZRem(ctx, deadLettersIndexKey, id).Err()
+}
@@ -0,0 +1,386 @@
+package image
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+const (
+	resizeJobsStream   = "img-resize:resize-jobs"
+	resizeJobsGroup    = "img-resize-workers"
+	delayedResizeJobs  = "img-resize:delayed-resize-jobs"
+	maxDelayedJobMoves = 100
+
+	// How long a worker blocks waiting for new jobs, before checking for
+	// stalled ones again.
+	redisQueueBlock = time.Second
+	// How long a worker waits before retrying, after Redis failed.
+	redisQueueRetryDelay = time.Second
+	// How often a job waiting for room in a full lane checks for it.
+	redisQueueFullPoll = 100 * time.Millisecond
+)
+
+// Atomically moves the delayed jobs that are due into the streams. The keys
+// come in pairs, each a sorted set of delayed jobs followed by its stream.
+var moveDelayedJobs = redis.NewScript(`
+local moved = 0
+for i = 1, #KEYS, 2 do
+	local jobs = redis.call('ZRANGEBYSCORE', KEYS[i], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+	for _, job in ipairs(jobs) do
+		redis.call('ZREM', KEYS[i], job)
+		redis.call('XADD', KEYS[i + 1], '*', 'job', job)
+	end
+	moved = moved + #jobs
+end
+return moved
+`)
+
+// Atomically adds the job to the stream, unless the stream already holds as
+// many messages as the lane's size, in which case it returns nil.
+var addBoundedJob = redis.NewScript(`
+if redis.call('XLEN', KEYS[1]) >= tonumber(ARGV[1]) then
+	return false
+end
+return redis.call('XADD', KEYS[1], '*', 'job', ARGV[2])
+`)
+
+// RedisJobQueue is a durable job queue, built on Redis streams, one per
+// priority lane, read by a consumer group that all instances share. Jobs
+// that a worker received but didn't acknowledge within the visibility
+// timeout, for instance because its instance crashed, are reclaimed by other
+// workers. Delayed jobs wait in a sorted set per lane, scored by the time
+// they're due, until a worker moves them to the lane's stream.
+type RedisJobQueue struct {
+	client    *redis.Client
+	settings  *settings.Settings
+	lanes     []laneConfig
+	scheduler *laneScheduler
+	consumer  string
+	ctx       context.Context // cancelled once the queue is closed
+	cancel    context.CancelFunc
+	grouped   bool
+	received  []*ResizeJob // read along with another job, not yet handed out
+	mu        sync.Mutex
+}
+
+func NewRedisJobQueue(client *redis.Client, settings *settings.Settings) JobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	lanes := laneConfigs(settings)
+	return &RedisJobQueue{
+		client:    client,
+		settings:  settings,
+		lanes:     lanes,
+		scheduler: newLaneScheduler(lanes),
+		consumer:  consumerName(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Jobs that were received but aren't acknowledged yet stay in the stream,
+// so they take room in the lane too.
+func (q *RedisJobQueue) Enqueue(ctx context.Context, job *ResizeJob) error {
+	if q.ctx.Err() != nil {
+		return ErrQueueClosed
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	lane := q.lanes[laneIndex(job.Priority)]
+	stream := laneKey(resizeJobsStream, lane.priority)
+	deadline := time.Now().Add(q.settings.Service.QueueFullWait)
+	for {
+		err := addBoundedJob.Run(ctx, q.client, []string{stream}, lane.size, data).Err()
+		if !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		// The lane is full, so the job either waits for a while for room or
+		// is rejected, depending on the lane's policy
+		if lane.fullPolicy != fullPolicyWait || time.Now().After(deadline) {
+			return ErrQueueFull
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrQueueFull
+		case <-q.ctx.Done():
+			return ErrQueueClosed
+		case <-time.After(redisQueueFullPoll):
+		}
+	}
+}
+
+func (q *RedisJobQueue) EnqueueAfter(ctx context.Context, job *ResizeJob, delay time.Duration) error {
+	if q.ctx.Err() != nil {
+		return ErrQueueClosed
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return q.client.ZAdd(ctx, laneKey(delayedResizeJobs, q.lanes[laneIndex(job.Priority)].priority), redis.Z{
+		Score:  float64(time.Now().Add(delay).UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+// Jobs left in the stream when the queue is closed stay there, for workers
+// of other instances, or of this one once it's restarted.
+func (q *RedisJobQueue) Receive(ctx context.Context) (*ResizeJob, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(q.ctx, cancel)
+	defer stop()
+
+	for {
+		job, err := q.receive(ctx)
+		if q.ctx.Err() != nil {
+			return nil, ErrQueueClosed
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if job != nil {
+			return job, nil
+		}
+		if err != nil {
+			log.Printf("failed to receive resize job: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(redisQueueRetryDelay):
+			}
+		}
+	}
+}
+
+// Jobs that are being run stay in their streams until they're acknowledged,
+// so they're counted too. Delayed jobs aren't.
+func (q *RedisJobQueue) Len(ctx context.Context) (int, error) {
+	cmds := make([]*redis.IntCmd, len(q.lanes))
+	_, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, lane := range q.lanes {
+			cmds[i] = pipe.XLen(ctx, laneKey(resizeJobsStream, lane.priority))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	size := 0
+	for _, cmd := range cmds {
+		size += int(cmd.Val())
+	}
+
+	return size, nil
+}
+
+func (q *RedisJobQueue) Ack(ctx context.Context, job *ResizeJob) error {
+	return q.ack(ctx, laneKey(resizeJobsStream, q.lanes[laneIndex(job.Priority)].priority), job.receipt)
+}
+
+// Acknowledges the stream message, and removes it from the stream.
+func (q *RedisJobQueue) ack(ctx context.Context, stream string, id string) error {
+	if err := q.client.XAck(ctx, stream, resizeJobsGroup, id).Err(); err != nil {
+		return err
+	}
+
+	return q.client.XDel(ctx, stream, id).Err()
+}
+
+// Claims the stream message again, which resets the time it's been idle, so
+// that it isn't reclaimed as stalled.
+func (q *RedisJobQueue) Extend(ctx context.Context, job *ResizeJob) error {
+	return q.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   laneKey(resizeJobsStream, q.lanes[laneIndex(job.Priority)].priority),
+		Group:    resizeJobsGroup,
+		Consumer: q.consumer,
+		MinIdle:  0,
+		Messages: []string{job.receipt},
+	}).Err()
+}
+
+func (q *RedisJobQueue) Close() error {
+	q.cancel()
+	return nil
+}
+
+// Jobs stay in the streams, and received jobs that weren't acknowledged are
+// reclaimed by the workers of any instance once the visibility timeout
+// passes.
+func (q *RedisJobQueue) Drain(_ context.Context, _ []*ResizeJob) ([]*ResizeJob, error) {
+	return nil, nil
+}
+
+// Receives a stalled job, if there's one, or else waits a while for a new
+// one. Returns a nil job if there's none. Lanes are looked into in the
+// order the scheduler picks.
+func (q *RedisJobQueue) receive(ctx context.Context) (*ResizeJob, error) {
+	if job := q.takeReceived(); job != nil {
+		return job, nil
+	}
+
+	if err := q.ensureGroups(ctx); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, 2*len(q.lanes))
+	for _, lane := range q.lanes {
+		keys = append(keys, laneKey(delayedResizeJobs, lane.priority), laneKey(resizeJobsStream, lane.priority))
+	}
+	err := moveDelayedJobs.Run(ctx, q.client, keys, time.Now().UnixMilli(), maxDelayedJobMoves).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	order := q.scheduler.order()
+	for _, i := range order {
+		stream := laneKey(resizeJobsStream, q.lanes[i].priority)
+		messages, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    resizeJobsGroup,
+			Consumer: q.consumer,
+			MinIdle:  q.settings.Service.QueueVisibilityTimeout,
+			Start:    "0-0",
+			Count:    1,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			log.Printf("reclaimed stalled resize job %s", messages[0].ID)
+			return q.decode(ctx, stream, messages[0])
+		}
+	}
+
+	streams := make([]string, 0, 2*len(order))
+	for _, i := range order {
+		streams = append(streams, laneKey(resizeJobsStream, q.lanes[i].priority))
+	}
+	for range order {
+		streams = append(streams, ">")
+	}
+
+	// A job may be read from each lane at once; the jobs other than the
+	// first are handed out by the following calls
+	result, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    resizeJobsGroup,
+		Consumer: q.consumer,
+		Streams:  streams,
+		Count:    1,
+		Block:    redisQueueBlock,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var received []*ResizeJob
+	for _, stream := range streams[:len(order)] {
+		for _, s := range result {
+			if s.Stream != stream || len(s.Messages) == 0 {
+				continue
+			}
+			if job, _ := q.decode(ctx, stream, s.Messages[0]); job != nil {
+				received = append(received, job)
+			}
+		}
+	}
+	if len(received) == 0 {
+		return nil, nil
+	}
+
+	q.mu.Lock()
+	q.received = append(q.received, received[1:]...)
+	q.mu.Unlock()
+
+	return received[0], nil
+}
+
+// Returns a job that was read along with another one, if there's one.
+func (q *RedisJobQueue) takeReceived() *ResizeJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.received) == 0 {
+		return nil
+	}
+
+	job := q.received[0]
+	q.received = q.received[1:]
+	return job
+}
+
+// Creates the consumer group, along with the stream, of each lane, unless
+// they exist.
+func (q *RedisJobQueue) ensureGroups(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.grouped {
+		return nil
+	}
+
+	for _, lane := range q.lanes {
+		err := q.client.XGroupCreateMkStream(ctx, laneKey(resizeJobsStream, lane.priority), resizeJobsGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+	q.grouped = true
+
+	return nil
+}
+
+// Decodes the job in the stream message. Messages that can't be decoded
+// would never succeed, so they're dropped.
+func (q *RedisJobQueue) decode(ctx context.Context, stream string, message redis.XMessage) (*ResizeJob, error) {
+	job := &ResizeJob{receipt: message.ID}
+
+	data, _ := message.Values["job"].(string)
+	if err := json.Unmarshal([]byte(data), job); err != nil {
+		log.Printf("dropping invalid resize job %s: %v", message.ID, err)
+		return nil, q.ack(ctx, stream, message.ID)
+	}
+
+	return job, nil
+}
+
+// Returns the key of the lane of the priority. The normal lane keeps the
+// keys from before there were lanes, so that jobs queued then aren't lost.
+func laneKey(key string, priority string) string {
+	if priority == priorityNormal {
+		return key
+	}
+
+	return key + ":" + priority
+}
+
+// Returns a consumer name unique to this instance.
+func consumerName() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
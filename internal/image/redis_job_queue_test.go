@@ -0,0 +1,208 @@
+package image_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+const (
+	testStream  = "img-resize:resize-jobs"
+	testGroup   = "img-resize-workers"
+	testDelayed = "img-resize:delayed-resize-jobs"
+)
+
+func TestRedisJobQueueEnqueue(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	job := &image.ResizeJob{BatchID: "batch", URL: "https://example.com/a.jpg", Transformations: []model.Transformation{{Width: 100}}, ImageIndexes: []int{0}}
+	data, _ := json.Marshal(job)
+
+	s := buildSettings()
+	s.Service.ResizeQueueSize = 9
+
+	// the script checks the lane's length, a third of the queue's size, and
+	// adds the job at once, returning nil once the lane is full
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", []string{testStream}, 3, data).SetVal("1-0")
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", []string{testStream}, 3, data).RedisNil()
+
+	// bulk jobs have a lane, and a stream, of their own
+	bulkJob := &image.ResizeJob{BatchID: "batch", URL: "https://example.com/b.jpg", Priority: "bulk"}
+	bulkData, _ := json.Marshal(bulkJob)
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", []string{testStream + ":bulk"}, 3, bulkData).SetVal("1-0")
+
+	queue := image.NewRedisJobQueue(db, s)
+	if err := queue.Enqueue(context.Background(), job); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := queue.Enqueue(context.Background(), job); !errors.Is(err, image.ErrQueueFull) {
+		t.Errorf("expected queue full error, got: %v", err)
+	}
+
+	if err := queue.Enqueue(context.Background(), bulkJob); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisJobQueueLen(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectXLen(testStream + ":high").SetVal(1)
+	mock.ExpectXLen(testStream).SetVal(20)
+	mock.ExpectXLen(testStream + ":bulk").SetVal(300)
+
+	queue := image.NewRedisJobQueue(db, buildSettings())
+	if size, err := queue.Len(context.Background()); err != nil || size != 321 {
+		t.Errorf("unexpected queue length: %d, %v", size, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisJobQueueReceive(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	s := buildSettings()
+	s.Service.QueueVisibilityTimeout = time.Minute
+	queue := image.NewRedisJobQueue(db, s)
+
+	highStream, bulkStream := testStream+":high", testStream+":bulk"
+	claim := func(stream string) *redismock.ExpectedXAutoClaim {
+		args := &redis.XAutoClaimArgs{Stream: stream, Group: testGroup, MinIdle: time.Minute, Start: "0-0", Count: 1}
+		return mock.CustomMatch(ignoreConsumer).ExpectXAutoClaim(args)
+	}
+	moveDelayed := func() *redismock.ExpectedCmd {
+		// neither the script hash nor the current time are matched
+		keys := []string{testDelayed + ":high", highStream, testDelayed, testStream, testDelayed + ":bulk", bulkStream}
+		return mock.CustomMatch(ignoreDelayedMove).ExpectEvalSha("", keys, 0, 100)
+	}
+
+	for _, stream := range []string{highStream, testStream, bulkStream} {
+		mock.ExpectXGroupCreateMkStream(stream, testGroup, "0").SetErr(errors.New("BUSYGROUP Consumer Group name already exists"))
+	}
+
+	// new jobs are read from all lanes once there are no stalled ones, and
+	// handed out from the lane picked first, the high one
+	moveDelayed().SetVal(int64(0))
+	for _, stream := range []string{highStream, testStream, bulkStream} {
+		claim(stream).SetVal([]redis.XMessage{}, "0-0")
+	}
+	mock.CustomMatch(ignoreConsumer).ExpectXReadGroup(&redis.XReadGroupArgs{Group: testGroup, Streams: []string{highStream, testStream, bulkStream, ">", ">", ">"}, Count: 1, Block: time.Second}).
+		SetVal([]redis.XStream{
+			{Stream: bulkStream, Messages: []redis.XMessage{{ID: "1-0", Values: map[string]any{"job": `{"url":"https://example.com/bulk.jpg","priority":"bulk"}`}}}},
+			{Stream: highStream, Messages: []redis.XMessage{{ID: "1-0", Values: map[string]any{"job": `{"url":"https://example.com/high.jpg","priority":"high"}`}}}},
+		})
+
+	// a stalled job is reclaimed, from the normal lane, picked first next
+	moveDelayed().SetVal(int64(1))
+	claim(testStream).SetVal([]redis.XMessage{{ID: "2-0", Values: map[string]any{"job": `{"url":"https://example.com/normal.jpg"}`}}}, "0-0")
+	mock.ExpectXAck(testStream, testGroup, "2-0").SetVal(1)
+	mock.ExpectXDel(testStream, "2-0").SetVal(1)
+
+	// failed expectations make the queue retry, until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, url := range []string{"https://example.com/high.jpg", "https://example.com/bulk.jpg", "https://example.com/normal.jpg"} {
+		job, err := queue.Receive(ctx)
+		if err != nil || job.URL != url {
+			t.Fatalf("unexpected job: %v, %v", job, err)
+		}
+
+		if url == "https://example.com/normal.jpg" {
+			if err := queue.Ack(context.Background(), job); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	_ = queue.Close()
+	if _, err := queue.Receive(ctx); !errors.Is(err, image.ErrQueueClosed) {
+		t.Errorf("expected queue closed error, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisJobQueueExtend(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	s := buildSettings()
+	s.Service.QueueVisibilityTimeout = time.Minute
+	queue := image.NewRedisJobQueue(db, s)
+
+	highStream, bulkStream := testStream+":high", testStream+":bulk"
+	for _, stream := range []string{highStream, testStream, bulkStream} {
+		mock.ExpectXGroupCreateMkStream(stream, testGroup, "0").SetVal("OK")
+	}
+	keys := []string{testDelayed + ":high", highStream, testDelayed, testStream, testDelayed + ":bulk", bulkStream}
+	mock.CustomMatch(ignoreDelayedMove).ExpectEvalSha("", keys, 0, 100).SetVal(int64(0))
+	mock.CustomMatch(ignoreConsumer).ExpectXAutoClaim(&redis.XAutoClaimArgs{Stream: highStream, Group: testGroup, MinIdle: time.Minute, Start: "0-0", Count: 1}).
+		SetVal([]redis.XMessage{{ID: "1-0", Values: map[string]any{"job": `{"url":"https://example.com/high.jpg","priority":"high"}`}}}, "0-0")
+
+	// the job's message is claimed again by the same consumer, which resets
+	// the time it's been idle
+	mock.CustomMatch(ignoreConsumer).ExpectXClaimJustID(&redis.XClaimArgs{Stream: highStream, Group: testGroup, MinIdle: 0, Messages: []string{"1-0"}}).
+		SetVal([]string{"1-0"})
+
+	job, err := queue.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := queue.Extend(context.Background(), job); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Matches stream commands regardless of the consumer name, which is unique
+// to each queue, and is the fourth argument of both XREADGROUP and
+// XAUTOCLAIM.
+func ignoreConsumer(expected, actual []any) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("unexpected command: %v", actual)
+	}
+
+	for i := range expected {
+		if i != 3 && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return fmt.Errorf("unexpected command: %v", actual)
+		}
+	}
+
+	return nil
+}
+
+// Matches the script that moves delayed jobs to the streams regardless of
+// its hash, the second argument of EVALSHA, and of the current time, the one
+// before the last.
+func ignoreDelayedMove(expected, actual []any) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("unexpected command: %v", actual)
+	}
+
+	for i := range expected {
+		if i != 1 && i != len(expected)-2 && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return fmt.Errorf("unexpected command: %v", actual)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package image
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+const (
+	resizingKeyPrefix  = "img-resize:resizing:"
+	resizedChannel     = "img-resize:resized"
+	cancelledKeyPrefix = "img-resize:cancelled:"
+	cancelledChannel   = "img-resize:cancelled"
+
+	// How long a cancelled resize is remembered, for waiters that find its
+	// lock gone to tell that it was cancelled rather than done.
+	resizingCancelledTTL = 10 * time.Second
+
+	// How often waiters check whether an image is still being resized, in
+	// case they missed the notice that it's done, or the resizing instance
+	// died without sending one.
+	resizingPollInterval = 250 * time.Millisecond
+)
+
+// Atomically takes the resizing lock in KEYS[1] for the owner in ARGV[1],
+// for ARGV[2] milliseconds, unless another owner holds it. Returns 1 if
+// another owner does.
+var acquireResizingLock = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 1
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 0
+`)
+
+// Atomically deletes the resizing lock in KEYS[1], if it's held by the owner
+// in ARGV[1], along with the mark of an earlier cancel in KEYS[2], and
+// publishes the image ID in ARGV[3] to the channel in ARGV[2]. Returns 1 if
+// the lock was deleted.
+var releaseResizingLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+redis.call('PUBLISH', ARGV[2], ARGV[3])
+return 1
+`)
+
+// Atomically deletes the resizing lock in KEYS[1], if it's held by the owner
+// in ARGV[1], marks the resize as cancelled in KEYS[2] for ARGV[4]
+// milliseconds, and publishes the image ID in ARGV[3] to the channel in
+// ARGV[2]. Returns 1 if the lock was deleted.
+var cancelResizingLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[2], 1, 'PX', ARGV[4])
+redis.call('DEL', KEYS[1])
+redis.call('PUBLISH', ARGV[2], ARGV[3])
+return 1
+`)
+
+// Atomically extends the resizing lock in KEYS[1] to ARGV[2] milliseconds,
+// if it's held by the owner in ARGV[1]. Returns 1 if the lock was extended.
+var refreshResizingLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+`)
+
+// RedisResizingProgress keeps track of the images being resized by any
+// instance. An image being resized is marked with a lock that expires after
+// SVC_RESIZING_LOCK_TTL, so that it's not held forever by an instance that
+// died, and waiters are notified when it's done or cancelled through pub/sub.
+// The lock holds its owner's token, so that an owner whose lock expired
+// doesn't release the lock another owner took meanwhile.
+type RedisResizingProgress struct {
+	client   *redis.Client
+	settings *settings.Settings
+	pubsub   *redis.PubSub
+	waiters  map[string][]chan ResizingOutcome
+	mu       sync.Mutex
+}
+
+func NewRedisResizingProgress(client *redis.Client, settings *settings.Settings) ResizingProgressAdapter {
+	return &RedisResizingProgress{
+		client:   client,
+		settings: settings,
+		waiters:  make(map[string][]chan ResizingOutcome),
+	}
+}
+
+// Atomically checks if an image is being resized by another owner, and marks
+// it as in progress by the owner otherwise.
+func (rp *RedisResizingProgress) CheckAndSetResizing(ctx context.Context, imageID string, owner string) bool {
+	keys := []string{resizingKeyPrefix + imageID}
+	held, err := acquireResizingLock.Run(ctx, rp.client, keys, owner, rp.settings.Service.ResizingLockTTL.Milliseconds()).Int()
+	if err != nil {
+		// Resizing an image twice beats not resizing it at all
+		log.Printf("error setting resizing lock: %v", err)
+		return false
+	}
+
+	return held == 1
+}
+
+// Checks if an image is being resized.
+func (rp *RedisResizingProgress) CheckResizing(ctx context.Context, imageID string) bool {
+	n, err := rp.client.Exists(ctx, resizingKeyPrefix+imageID).Result()
+	if err != nil {
+		log.Printf("error reading resizing lock: %v", err)
+		return false
+	}
+
+	return n > 0
+}
+
+// Extends the lock of the image for another SVC_RESIZING_LOCK_TTL, if the
+// owner holds it. Reports whether it does.
+func (rp *RedisResizingProgress) RefreshResizing(ctx context.Context, imageID string, owner string) bool {
+	keys := []string{resizingKeyPrefix + imageID}
+	n, err := refreshResizingLock.Run(ctx, rp.client, keys, owner, rp.settings.Service.ResizingLockTTL.Milliseconds()).Int()
+	if err != nil {
+		log.Printf("error refreshing resizing lock: %v", err)
+		return false
+	}
+
+	return n == 1
+}
+
+// Unmarks the image as being resized, if the owner holds its lock, and
+// notifies the waiters on all instances.
+func (rp *RedisResizingProgress) DeleteResizing(ctx context.Context, imageID string, owner string) {
+	keys := []string{resizingKeyPrefix + imageID, cancelledKeyPrefix + imageID}
+	if err := releaseResizingLock.Run(ctx, rp.client, keys, owner, resizedChannel, imageID).Err(); err != nil {
+		log.Printf("error deleting resizing lock: %v", err)
+	}
+}
+
+// Unmarks the image as being resized, if the owner holds its lock, and
+// notifies the waiters on all instances that its resize was cancelled.
+func (rp *RedisResizingProgress) CancelResizing(ctx context.Context, imageID string, owner string) {
+	keys := []string{resizingKeyPrefix + imageID, cancelledKeyPrefix + imageID}
+	err := cancelResizingLock.Run(ctx, rp.client, keys, owner, cancelledChannel, imageID, resizingCancelledTTL.Milliseconds()).Err()
+	if err != nil {
+		log.Printf("error cancelling resizing lock: %v", err)
+	}
+}
+
+// Synchronously blocks until the image has been resized or the timer expires,
+// whichever happens first.
+func (rp *RedisResizingProgress) WaitForResizingDone(ctx context.Context, imageID string) ResizingOutcome {
+	if !rp.CheckResizing(ctx, imageID) {
+		return ResizingDone
+	}
+
+	log.Printf("waiting for resize of %s to finish", imageID)
+
+	done := rp.addWaiter(imageID)
+	defer rp.removeWaiter(imageID, done)
+
+	if err := rp.subscribe(ctx); err != nil {
+		log.Printf("error subscribing to resize notices: %v", err)
+	}
+
+	timeout := time.NewTimer(rp.settings.Service.ImageResizeTimeout)
+	defer timeout.Stop()
+	poll := time.NewTicker(resizingPollInterval)
+	defer poll.Stop()
+
+	for {
+		// Checking after the waiter is added catches images that were done
+		// before it got notified
+		if !rp.CheckResizing(ctx, imageID) {
+			return rp.releasedOutcome(ctx, imageID)
+		}
+
+		select {
+		case outcome := <-done:
+			return outcome
+		case <-poll.C:
+		case <-ctx.Done():
+			return ResizingTimedOut
+		case <-timeout.C:
+			return ResizingTimedOut
+		}
+	}
+}
+
+// Tells whether the resize of an image whose lock is gone was cancelled or
+// done.
+func (rp *RedisResizingProgress) releasedOutcome(ctx context.Context, imageID string) ResizingOutcome {
+	n, err := rp.client.Exists(ctx, cancelledKeyPrefix+imageID).Result()
+	if err != nil {
+		log.Printf("error reading cancelled resize: %v", err)
+		return ResizingDone
+	}
+	if n > 0 {
+		return ResizingCancelled
+	}
+
+	return ResizingDone
+}
+
+// Subscribes to resize and cancel notices, once for all waiters.
+func (rp *RedisResizingProgress) subscribe(ctx context.Context) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.pubsub != nil {
+		return nil
+	}
+
+	pubsub := rp.client.Subscribe(context.Background(), resizedChannel, cancelledChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+	rp.pubsub = pubsub
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			outcome := ResizingDone
+			if msg.Channel == cancelledChannel {
+				outcome = ResizingCancelled
+			}
+			rp.notifyWaiters(msg.Payload, outcome)
+		}
+	}()
+
+	return nil
+}
+
+func (rp *RedisResizingProgress) addWaiter(imageID string) chan ResizingOutcome {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	ch := make(chan ResizingOutcome, 1)
+	rp.waiters[imageID] = append(rp.waiters[imageID], ch)
+
+	return ch
+}
+
+func (rp *RedisResizingProgress) removeWaiter(imageID string, ch chan ResizingOutcome) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	waiters := rp.waiters[imageID]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(rp.waiters, imageID)
+	} else {
+		rp.waiters[imageID] = waiters
+	}
+}
+
+func (rp *RedisResizingProgress) notifyWaiters(imageID string, outcome ResizingOutcome) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	for _, ch := range rp.waiters[imageID] {
+		ch <- outcome
+	}
+	delete(rp.waiters, imageID)
+}
@@ -0,0 +1,155 @@
+package image_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+
+	"github.com/okulik/img-resize/internal/image"
+)
+
+func TestRedisResizingProgressCheckAndSet(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	s := buildSettings()
+	s.Service.ResizingLockTTL = time.Minute
+	keys := []string{"img-resize:resizing:abc"}
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", keys, "owner", 60000).SetVal(int64(0))
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", keys, "other", 60000).SetVal(int64(1))
+	// the lock is released, and the notice published, only by its owner
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", []string{"img-resize:resizing:abc", "img-resize:cancelled:abc"}, "owner", "img-resize:resized", "abc").SetVal(int64(1))
+
+	progress := image.NewRedisResizingProgress(db, s)
+
+	if progress.CheckAndSetResizing(context.Background(), "abc", "owner") {
+		t.Error("expected image not to be resizing yet")
+	}
+
+	if !progress.CheckAndSetResizing(context.Background(), "abc", "other") {
+		t.Error("expected image to be resizing")
+	}
+
+	progress.DeleteResizing(context.Background(), "abc", "owner")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisResizingProgressWait(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectExists("img-resize:resizing:done").SetVal(0)
+	mock.ExpectExists("img-resize:resizing:abc").SetVal(1)
+	mock.ExpectExists("img-resize:resizing:abc").SetVal(1)
+	mock.ExpectExists("img-resize:resizing:abc").SetVal(0)
+	mock.ExpectExists("img-resize:cancelled:abc").SetVal(0)
+
+	progress := image.NewRedisResizingProgress(db, buildSettings())
+
+	if progress.WaitForResizingDone(context.Background(), "done") != image.ResizingDone {
+		t.Error("expected wait for an image not being resized to return right away")
+	}
+
+	// without resize notices, waiters find out by polling
+	if progress.WaitForResizingDone(context.Background(), "abc") != image.ResizingDone {
+		t.Error("expected wait to succeed once the lock is gone")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisResizingProgressCancel(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	keys := []string{"img-resize:resizing:abc", "img-resize:cancelled:abc"}
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", keys, "owner", "img-resize:cancelled", "abc", 10000).SetVal(int64(1))
+	mock.ExpectExists("img-resize:resizing:abc").SetVal(1)
+	mock.ExpectExists("img-resize:resizing:abc").SetVal(0)
+	mock.ExpectExists("img-resize:cancelled:abc").SetVal(1)
+
+	progress := image.NewRedisResizingProgress(db, buildSettings())
+
+	progress.CancelResizing(context.Background(), "abc", "owner")
+
+	// waiters that find the lock gone tell a cancelled resize by its marker
+	if progress.WaitForResizingDone(context.Background(), "abc") != image.ResizingCancelled {
+		t.Error("expected wait to report the resize as cancelled")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisResizingProgressRefresh(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	s := buildSettings()
+	s.Service.ResizingLockTTL = time.Minute
+	keys := []string{"img-resize:resizing:abc"}
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", keys, "owner", 60000).SetVal(int64(1))
+	mock.CustomMatch(ignoreScriptHash).ExpectEvalSha("", keys, "other", 60000).SetVal(int64(0))
+
+	progress := image.NewRedisResizingProgress(db, s)
+
+	if !progress.RefreshResizing(context.Background(), "abc", "owner") {
+		t.Error("expected the owner's lock to be extended")
+	}
+
+	if progress.RefreshResizing(context.Background(), "abc", "other") {
+		t.Error("expected another owner's lock not to be extended")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalResizingProgressOwners(t *testing.T) {
+	progress := image.NewLocalResizingProgress(buildSettings())
+	ctx := context.Background()
+
+	if progress.CheckAndSetResizing(ctx, "abc", "owner") {
+		t.Fatal("expected image not to be resizing yet")
+	}
+
+	// the owner may take its mark again, as a restored job does
+	if progress.CheckAndSetResizing(ctx, "abc", "owner") || !progress.CheckAndSetResizing(ctx, "abc", "other") {
+		t.Error("expected only another owner to find the image resizing")
+	}
+
+	// another owner neither extends nor releases the mark
+	if progress.RefreshResizing(ctx, "abc", "other") {
+		t.Error("expected another owner's mark not to be extended")
+	}
+	progress.DeleteResizing(ctx, "abc", "other")
+	progress.CancelResizing(ctx, "abc", "other")
+	if !progress.CheckResizing(ctx, "abc") {
+		t.Fatal("expected image to be resizing still")
+	}
+
+	if !progress.RefreshResizing(ctx, "abc", "owner") {
+		t.Error("expected the owner's mark to be extended")
+	}
+	progress.DeleteResizing(ctx, "abc", "owner")
+	if progress.CheckResizing(ctx, "abc") {
+		t.Error("expected image not to be resizing once released")
+	}
+}
+
+// Matches script calls without their hash.
+func ignoreScriptHash(expected, actual []any) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("unexpected command: %v", actual)
+	}
+
+	for i := range expected {
+		if i != 1 && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return fmt.Errorf("unexpected command: %v", actual)
+		}
+	}
+
+	return nil
+}
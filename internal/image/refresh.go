@@ -0,0 +1,71 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// Refetches the source image at url and resizes it into each of the
+// variants, replacing the cached images, if it changed since the given
+// version. The source is fetched conditionally, unless some of the variants
+// are no longer cached, and its bytes are compared with the version's
+// digest, for origins that don't support conditional requests. Variants
+// missing from the cache are resized even if the source didn't change.
+// Returns the version of the source fetched, and whether it changed.
+func (r *Resizer) Refresh(ctx context.Context, url string, variants []model.Transformation, version model.SourceVersion) (model.SourceVersion, bool, error) {
+	if err := r.pool.Acquire(ctx); err != nil {
+		return version, false, err
+	}
+	defer r.pool.Release()
+
+	missing := make([]bool, len(variants))
+	conditional := version
+	for i, t := range variants {
+		if !r.imageCache.Contains(ctx, genImageID(url, t)) {
+			missing[i] = true
+			conditional = model.SourceVersion{}
+		}
+	}
+
+	data, fetched, err := r.fetchSource(ctx, url, conditional)
+	if err != nil {
+		return version, false, err
+	}
+	if data == nil {
+		return version, false, nil
+	}
+
+	fetched.Digest = sourceDigest(data)
+	changed := fetched.Digest != version.Digest
+
+	var src *sourceImage
+	for i, t := range variants {
+		if !changed && !missing[i] {
+			continue
+		}
+
+		if src == nil {
+			if src, err = r.decode(ctx, data); err != nil {
+				return version, false, err
+			}
+		}
+
+		resized, err := r.resize(ctx, src, t)
+		if err != nil {
+			return version, false, err
+		}
+
+		r.cacheImage(ctx, url, genImageID(url, t), resized)
+	}
+
+	return fetched, changed, nil
+}
+
+// Returns the hex encoded SHA-256 digest of the source image's bytes.
+func sourceDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,65 @@
+package image_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+func TestResizerRefresh(t *testing.T) {
+	sources := [][]byte{encodeTestPNG(t, 40, 20), encodeTestPNG(t, 20, 40)}
+	var version, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := []string{`"v0"`, `"v1"`}[version.Load()]
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(sources[version.Load()])
+	}))
+	defer server.Close()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+	ctx := context.Background()
+	variants := []model.Transformation{{Width: 10, Format: "png"}}
+
+	// the first refresh caches the variants
+	v0, changed, err := resizer.Refresh(ctx, server.URL, variants, model.SourceVersion{})
+	if err != nil || !changed || v0.ETag != `"v0"` || v0.Digest == "" {
+		t.Fatalf("unexpected refresh: %v, %v, %v", v0, changed, err)
+	}
+
+	first, _ := resizer.Transform(ctx, server.URL, variants[0])
+
+	// an unchanged source isn't resized again
+	v, changed, err := resizer.Refresh(ctx, server.URL, variants, v0)
+	if err != nil || changed || v != v0 || notModified.Load() != 1 {
+		t.Errorf("expected source not to change: %v, %v, %v", v, changed, err)
+	}
+
+	// neither is one whose origin doesn't support conditional requests
+	v, changed, err = resizer.Refresh(ctx, server.URL, variants, model.SourceVersion{Digest: v0.Digest})
+	if err != nil || changed || v.Digest != v0.Digest {
+		t.Errorf("expected source not to change: %v, %v, %v", v, changed, err)
+	}
+
+	version.Store(1)
+	v1, changed, err := resizer.Refresh(ctx, server.URL, variants, v0)
+	if err != nil || !changed || v1.ETag != `"v1"` || v1.Digest == v0.Digest {
+		t.Fatalf("expected source to change: %v, %v, %v", v1, changed, err)
+	}
+
+	second, _ := resizer.Transform(ctx, server.URL, variants[0])
+	if string(first) == string(second) {
+		t.Error("expected cached variant to be replaced")
+	}
+}
@@ -1,39 +1,64 @@
 package image
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"image/jpeg"
+	goimage "image"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"runtime"
 	"sync"
-
-	jpgresize "github.com/nfnt/resize"
+	"time"
 
 	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/fetch"
+	"github.com/okulik/img-resize/internal/jobs"
 	"github.com/okulik/img-resize/internal/model"
 	"github.com/okulik/img-resize/internal/settings"
+	"github.com/okulik/img-resize/internal/webhook"
 )
 
 const (
-	maxResizeJobsSize   = 10000
-	maxResizeJobWorkers = 4
+	// Unless configured otherwise, there's an async worker per CPU, and room
+	// in the queue for this many jobs per CPU.
+	resizeJobsPerCPU = 2500
+	// Bounds the pool of async workers, when it's resized at runtime.
+	maxResizeJobWorkers = 1024
+
+	// Bounds the time clients are told to wait before trying again, when
+	// their jobs aren't enqueued.
+	minRetryAfter = time.Second
+	maxRetryAfter = 5 * time.Minute
 
 	statusSuccess  = "success"
 	statusFailure  = "failure"
 	statusEnqueued = "enqueued"
 )
 
-// ResizeJob represents a single image resize task.
+// Reported for images of a job that were left to another job to resize,
+// when that job didn't resize them.
+var errConcurrentResizeFailed = errors.New("concurrent resize of the image failed")
+
+// ResizeJob represents a single image resize task, producing one or more
+// variants of the image at the URL. ImageIndexes point to the images of the
+// batch's job record, one per transformation. Attempt counts the times the
+// job has been run. The job is queued in the lane of its Priority.
 type ResizeJob struct {
-	URL    string
-	Width  uint
-	Height uint
+	BatchID         string                 `json:"batch_id"`
+	URL             string                 `json:"url"`
+	Priority        string                 `json:"priority,omitempty"`
+	Transformations []model.Transformation `json:"transformations"`
+	ImageIndexes    []int                  `json:"image_indexes"`
+	Attempt         int                    `json:"attempt"`
+
+	receipt string // identifies the received job to its queue
 }
 
 // Resizer represents an image resizing engine. It supports both
@@ -41,22 +66,93 @@ type ResizeJob struct {
 type Resizer struct {
 	settings         *settings.Settings
 	imageCache       cache.ImageCacheAdapter
-	resizeJobs       chan *ResizeJob
-	resizingProgress *ResizingProgress
+	sourceIndex      cache.SourceIndexAdapter // image IDs of the cached images, by source URL
+	queue            JobQueue
+	resizingProgress ResizingProgressAdapter
+	jobs             jobs.JobStoreAdapter
+	deadLetters      DeadLetterStoreAdapter
+	notifier         *webhook.Notifier
+	formats          *FormatRegistry
+	httpClient       *http.Client
+	pool             semaphore        // shared by sync requests and async workers
+	cpu              semaphore        // bounds decoding, resizing and encoding
+	throughput       *throughputMeter // of async jobs
+	snapshots        JobSnapshotAdapter
+	runs             context.Context // of async jobs; done once the shutdown aborts them
+	abortRuns        context.CancelFunc
+	deliveries       context.Context // of callbacks; done once the shutdown aborts them
+	abortDeliveries  context.CancelFunc
+	callbacks        sync.WaitGroup       // callbacks being delivered
+	workers          []context.CancelFunc // each lets an async worker go
+	stopped          bool                 // no workers are started once stopped
+	interrupted      []*ResizeJob         // jobs aborted by the shutdown, not acknowledged
+	workersMu        sync.Mutex
 	wg               sync.WaitGroup
 }
 
-// Creates a new instance of the Resizer object.
+// sourceImage is a decoded source image, already turned upright.
+type sourceImage struct {
+	data        []byte
+	img         goimage.Image
+	format      *ImageFormat
+	orientation int
+}
+
+// ResizerBackends holds the state the resizer keeps outside of its workers,
+// which may be shared with other instances. Snapshots keep the jobs left
+// unprocessed at shutdown, until the resizer is started again. SourceIndex
+// tells which cached images were derived from a source image.
+type ResizerBackends struct {
+	Queue            JobQueue
+	Jobs             jobs.JobStoreAdapter
+	ResizingProgress ResizingProgressAdapter
+	DeadLetters      DeadLetterStoreAdapter
+	Snapshots        JobSnapshotAdapter
+	SourceIndex      cache.SourceIndexAdapter
+}
+
+// Creates a new instance of the Resizer object, keeping async jobs, their
+// records and the images being resized in memory.
 func NewResizer(settings *settings.Settings, imageCache cache.ImageCacheAdapter) *Resizer {
+	return NewResizerWithBackends(settings, imageCache, ResizerBackends{
+		Queue:            NewMemoryJobQueue(settings),
+		Jobs:             jobs.NewMemoryJobStore(settings.Service.JobRetention),
+		ResizingProgress: NewLocalResizingProgress(settings),
+		DeadLetters:      NewMemoryDeadLetterStore(),
+		Snapshots:        NewFileJobSnapshot(settings.Service.JobSnapshotPath),
+		SourceIndex:      cache.NewMemorySourceIndex(settings.Service.ImageCacheSize),
+	})
+}
+
+// Creates a new instance of the Resizer object, with the given backends.
+func NewResizerWithBackends(settings *settings.Settings, imageCache cache.ImageCacheAdapter, backends ResizerBackends) *Resizer {
+	runs, abortRuns := context.WithCancel(context.Background())
+	deliveries, abortDeliveries := context.WithCancel(context.Background())
 	return &Resizer{
 		settings:         settings,
 		imageCache:       imageCache,
-		resizeJobs:       make(chan *ResizeJob, maxResizeJobsSize),
-		resizingProgress: NewResizingProgress(settings),
+		queue:            backends.Queue,
+		resizingProgress: backends.ResizingProgress,
+		jobs:             backends.Jobs,
+		deadLetters:      backends.DeadLetters,
+		snapshots:        backends.Snapshots,
+		sourceIndex:      backends.SourceIndex,
+		runs:             runs,
+		abortRuns:        abortRuns,
+		deliveries:       deliveries,
+		abortDeliveries:  abortDeliveries,
+		notifier:         webhook.NewNotifier(settings),
+		formats:          DefaultFormats(),
+		httpClient:       fetch.NewClient(settings),
+		pool:             newSemaphore(settings.Service.Concurrency),
+		cpu:              newSemaphore(cpuConcurrency(settings)),
+		throughput:       newThroughputMeter(time.Now()),
 	}
 }
 
-// Starts a pool of background workers for async image resizing.
+// Starts a pool of background workers for async image resizing, and
+// enqueues the jobs that were left unprocessed when the resizer last shut
+// down.
 func (r *Resizer) Start() {
 	if !r.settings.Service.AsyncResize {
 		return
@@ -64,171 +160,1240 @@ func (r *Resizer) Start() {
 
 	log.Print("async resizing enabled")
 
-	for i := 0; i < maxResizeJobWorkers; i++ {
-		r.wg.Add(1)
-		go func() {
-			defer r.wg.Done()
-
-			for job := range r.resizeJobs {
-				_, _ = r.processImageResize(context.Background(), job.URL, job.Width, job.Height)
-				r.resizingProgress.DeleteResizing(genImageID(job.URL, job.Width, job.Height))
-			}
-		}()
+	if err := r.SetWorkers(resizeWorkers(r.settings)); err != nil {
+		log.Printf("failed to start resize workers: %v", err)
 	}
+
+	r.restoreJobs(context.Background())
 }
 
-// Stops all background workers.
+// Stops all background workers, once they've drained the queue. If they
+// don't within SVC_SHUTDOWN_DRAIN_TIMEOUT, the jobs they're running are
+// aborted, and those, along with the jobs still queued, are saved to the
+// snapshot, to be enqueued once the resizer is started again. Callbacks
+// being delivered are waited for within the same timeout, and abandoned
+// once it passes.
 func (r *Resizer) Shutdown() {
 	if !r.settings.Service.AsyncResize {
 		return
 	}
 
-	close(r.resizeJobs)
-	r.wg.Wait()
+	r.workersMu.Lock()
+	r.stopped = true
+	r.workersMu.Unlock()
+
+	if err := r.queue.Close(); err != nil {
+		log.Printf("failed to close resize queue: %v", err)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(drained)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if drainTimeout := r.settings.Service.ShutdownDrainTimeout; drainTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), drainTimeout)
+	}
+	defer cancel()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Print("resize queue not drained in time, aborting resize jobs")
+		r.workersMu.Lock()
+		for _, cancel := range r.workers {
+			cancel()
+		}
+		r.workersMu.Unlock()
+		r.abortRuns()
+		<-drained
+	}
+
+	r.saveJobs(context.Background())
+	r.waitForCallbacks(ctx)
+}
+
+// Waits for the callbacks being delivered, aborting their deliveries once
+// the context is done.
+func (r *Resizer) waitForCallbacks(ctx context.Context) {
+	delivered := make(chan struct{})
+	go func() {
+		r.callbacks.Wait()
+		close(delivered)
+	}()
+
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+		log.Print("callbacks not delivered in time, aborting them")
+		r.abortDeliveries()
+		<-delivered
+	}
+}
+
+// Saves the jobs left in the queue, and those aborted by the shutdown, to
+// the snapshot.
+func (r *Resizer) saveJobs(ctx context.Context) {
+	r.workersMu.Lock()
+	interrupted := r.interrupted
+	r.workersMu.Unlock()
+
+	jobs, err := r.queue.Drain(ctx, interrupted)
+	if err != nil {
+		log.Printf("failed to drain resize queue: %v", err)
+		return
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	if err := r.snapshots.Save(ctx, jobs); err != nil {
+		log.Printf("dropping %d unprocessed resize jobs: %v", len(jobs), err)
+		return
+	}
+	log.Printf("saved %d unprocessed resize jobs", len(jobs))
+}
+
+// Enqueues the jobs saved to the snapshot at the last shutdown. Their images
+// are marked as being resized once more, as they were when first enqueued;
+// those another job is resizing meanwhile are followed instead.
+func (r *Resizer) restoreJobs(ctx context.Context) {
+	jobs, err := r.snapshots.Load(ctx)
+	if err != nil {
+		log.Printf("failed to load saved resize jobs: %v", err)
+		return
+	}
+
+	restored := 0
+	for _, job := range jobs {
+		if followed := r.claimJobImages(ctx, job); len(followed) > 0 {
+			r.updateJob(ctx, job.BatchID, func(j *model.Job, _ time.Time) {
+				for idx := range followed {
+					j.Images[idx].Followed = true
+				}
+			})
+			r.followJobImages(job, followed)
+
+			if job = activeResizeJob(job, followed); job == nil {
+				continue
+			}
+		}
+
+		if err := r.queue.Enqueue(ctx, job); err != nil {
+			log.Printf("dropping saved resize job of %s: %v", job.URL, err)
+			for _, t := range job.Transformations {
+				r.resizingProgress.DeleteResizing(ctx, genImageID(job.URL, t), job.BatchID)
+			}
+			continue
+		}
+		restored++
+	}
+
+	if restored > 0 {
+		log.Printf("restored %d saved resize jobs", restored)
+	}
+}
+
+// Returns the number of background workers.
+func (r *Resizer) Workers() int {
+	r.workersMu.Lock()
+	defer r.workersMu.Unlock()
+
+	return len(r.workers)
+}
+
+// Grows or shrinks the pool of background workers to n workers. Workers that
+// are let go finish the job they're running before they stop.
+func (r *Resizer) SetWorkers(n int) error {
+	if !r.settings.Service.AsyncResize {
+		return ErrAsyncResizeDisabled
+	}
+	if n < 1 || n > maxResizeJobWorkers {
+		return fmt.Errorf("number of workers must be between 1 and %d", maxResizeJobWorkers)
+	}
+
+	r.workersMu.Lock()
+	defer r.workersMu.Unlock()
+
+	if r.stopped {
+		return ErrResizerStopped
+	}
+
+	for len(r.workers) < n {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.workers = append(r.workers, cancel)
+		r.wg.Add(1)
+		go r.runWorker(ctx)
+	}
+	for len(r.workers) > n {
+		r.workers[len(r.workers)-1]()
+		r.workers = r.workers[:len(r.workers)-1]
+	}
+
+	return nil
+}
+
+// Runs async resize jobs until the queue is closed, or the worker is let go.
+func (r *Resizer) runWorker(ctx context.Context) {
+	defer r.wg.Done()
+
+	for ctx.Err() == nil {
+		job, err := r.queue.Receive(ctx)
+		if err != nil {
+			return
+		}
+
+		// A job that's received is done, even if the worker is let go
+		// meanwhile, unless the shutdown aborts it. It's kept from being
+		// handed to another worker until it's acknowledged.
+		keepCtx, stopKeeping := context.WithCancel(context.Background())
+		go r.keepReceived(keepCtx, job)
+		done := r.runResizeJob(job)
+		stopKeeping()
+		if !done {
+			r.workersMu.Lock()
+			r.interrupted = append(r.interrupted, job)
+			r.workersMu.Unlock()
+			continue
+		}
+		r.throughput.Mark(time.Now())
+		if err := r.queue.Ack(context.Background(), job); err != nil {
+			log.Printf("failed to acknowledge resize job of %s: %v", job.URL, err)
+		}
+	}
 }
 
 // Resize a batch of images, identified by their URLs, asynchronously. This
 // method processes the images provided in the request by enqueueing each of
-// them into the image resizing channel. Once all requested images are enqueued,
+// them into the job queue. Once all requested images are enqueued,
 // the method returns immediately with basic information, such as image IDs.
 // These IDs can be used in subsequent calls to retrieve the resized images from
-// the cache.
+// the cache. The result holds one response per URL, in the same order as in
+// the request, each carrying the batch ID of the job that tracks the images.
 func (r *Resizer) ProcessAsync(request *model.ResizeRequest) []model.ResizeResponse {
+	ctx := context.Background()
 	results := make([]model.ResizeResponse, 0, len(request.URLs))
+	transformations := requestTransformations(request)
 
+	// The job lists the images of all URLs, one per transformation
+	batchID := newBatchID()
+	images := make([]model.JobImage, 0, len(request.URLs)*len(transformations))
 	for _, url := range request.URLs {
-		imageID := genImageID(url, request.Width, request.Height)
+		for _, t := range transformations {
+			images = append(images, model.JobImage{URL: url, ID: genImageID(url, t)})
+		}
+	}
+	job := model.NewJob(batchID, images, time.Now())
+	job.Priority = request.Priority
+	if request.CallbackURL != "" {
+		job.Callback = &model.JobCallback{URL: request.CallbackURL, State: model.CallbackStatePending}
+	}
+	if err := r.jobs.Create(ctx, job); err != nil {
+		log.Printf("failed to create job %s: %v", batchID, err)
+	}
 
-		// Check if the image is cached
-		if r.imageCache.Contains(context.Background(), imageID) {
-			results = append(results, model.ResizeResponse{ID: imageID, Result: statusSuccess, Cached: true})
-			continue
+	// Images that are done right away, and images resized by other jobs
+	finished := map[int]model.ResizeResponse{}
+	followed := map[int]*ResizeJob{}
+	claimed := map[string]bool{} // images of the batch marked as being resized by it
+	var retryAfter time.Duration // estimated once a job isn't enqueued
+
+	for u, url := range request.URLs {
+		variants := make([]model.ResizeResponse, len(transformations))
+		pending := make([]model.Transformation, 0, len(transformations))
+		pendingIdx := make([]int, 0, len(transformations))
+
+		for i, t := range transformations {
+			imageID := genImageID(url, t)
+			jobIdx := u*len(transformations) + i
+
+			// Check if the image is cached
+			if r.imageCache.Contains(ctx, imageID) {
+				variants[i] = model.ResizeResponse{ID: imageID, Result: statusSuccess, Cached: true}
+				finished[jobIdx] = variants[i]
+				continue
+			}
+
+			// Check if the image is already being resized, by another batch or
+			// for an earlier URL of this one; if not, mark it as being resized
+			if claimed[imageID] || r.resizingProgress.CheckAndSetResizing(ctx, imageID, batchID) {
+				variants[i] = model.ResizeResponse{ID: imageID, Result: statusEnqueued, Cached: false}
+				followed[jobIdx] = &ResizeJob{BatchID: batchID, URL: url, Priority: request.Priority, Transformations: []model.Transformation{t}, ImageIndexes: []int{jobIdx}}
+				continue
+			}
+
+			claimed[imageID] = true
+			pending = append(pending, t)
+			pendingIdx = append(pendingIdx, i)
 		}
 
-		// Check if the image is already being resized; if not, mark it as being resized
-		if r.resizingProgress.CheckAndSetResizing(imageID) {
-			results = append(results, model.ResizeResponse{ID: imageID, Result: statusEnqueued, Cached: false})
-			continue
+		if len(pending) > 0 {
+			jobIdxs := make([]int, len(pendingIdx))
+			for j, i := range pendingIdx {
+				jobIdxs[j] = u*len(transformations) + i
+			}
+
+			err := r.trySendResizeJob(ctx, batchID, url, request.Priority, pending, jobIdxs)
+			if err != nil {
+				log.Printf("failed to enqueue resize job of %s: %v", url, err)
+				if retryAfter == 0 {
+					retryAfter = r.retryAfter(ctx)
+				}
+			}
+
+			for j, t := range pending {
+				imageID := genImageID(url, t)
+				if err != nil {
+					variants[pendingIdx[j]] = FailureResponse(err)
+					variants[pendingIdx[j]].ID = imageID
+					variants[pendingIdx[j]].RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+					finished[jobIdxs[j]] = variants[pendingIdx[j]]
+					r.resizingProgress.DeleteResizing(ctx, imageID, batchID)
+					continue
+				}
+				variants[pendingIdx[j]] = model.ResizeResponse{ID: imageID, Result: statusEnqueued, Cached: false}
+			}
 		}
 
-		if ok := r.trySendResizeJob(url, request.Width, request.Height); !ok {
-			log.Print("image resize queue full, try later")
-			results = append(results, model.ResizeResponse{Result: statusFailure})
-			r.resizingProgress.DeleteResizing(imageID)
-			continue
+		resp := newResizeResponse(url, variants, len(request.Variants) > 0)
+		resp.BatchID = batchID
+		for _, variant := range variants {
+			resp.RetryAfter = max(resp.RetryAfter, variant.RetryAfter)
 		}
+		results = append(results, resp)
+	}
+
+	if len(finished) > 0 || len(followed) > 0 {
+		r.updateJob(ctx, batchID, func(job *model.Job, now time.Time) {
+			for idx, resp := range finished {
+				job.Images[idx].Finish(resp, now)
+			}
+			for idx := range followed {
+				job.Images[idx].Followed = true
+			}
+		})
+	}
 
-		results = append(results, model.ResizeResponse{ID: imageID, Result: statusEnqueued, Cached: false})
+	for _, following := range followed {
+		go r.followJobImage(following)
+	}
+
+	// A job without images is done before it starts
+	if len(images) == 0 && job.Callback != nil {
+		done := job.Clone()
+		r.callbacks.Go(func() { r.deliverCallback(done) })
 	}
 
 	return results
 }
 
-// Synchronously resize a batch of images, identified by their URLs.
+// Synchronously resize a batch of images, identified by their URLs. The images
+// are resized in parallel, bounded by the pool shared with the async workers.
+// The result holds one response per URL, in the same order as in the request,
+// with the details of the error for each image that failed to resize, or that
+// didn't get resized before the context was done.
 func (r *Resizer) Process(request *model.ResizeRequest, ctx context.Context) ([]model.ResizeResponse, error) {
-	results := make([]model.ResizeResponse, 0, len(request.URLs))
+	results := make([]model.ResizeResponse, len(request.URLs))
+	err := r.ProcessStream(request, ctx, func(i int, resp model.ResizeResponse) {
+		results[i] = resp
+	})
 
-	for _, url := range request.URLs {
-		resp, err := r.processImageResize(ctx, url, request.Width, request.Height)
-		if err != nil {
-			results = append(results, resp)
+	return results, err
+}
+
+// Synchronously resize a batch of images, like Process does, but pass the
+// response for each image to emit as soon as the image is done, along with
+// the index of its URL in the request. Emit is only called from the calling
+// goroutine, so a slow emit never holds up the images still being resized.
+func (r *Resizer) ProcessStream(request *model.ResizeRequest, ctx context.Context, emit func(int, model.ResizeResponse)) error {
+	transformations := requestTransformations(request)
+
+	type streamResult struct {
+		index int
+		resp  model.ResizeResponse
+	}
+
+	// Buffered for every URL, so that workers never wait on the writer
+	results := make(chan streamResult, len(request.URLs))
+	for i, url := range request.URLs {
+		go func() {
+			results <- streamResult{i, r.processBatchImage(ctx, url, transformations, len(request.Variants) > 0)}
+		}()
+	}
+	for range request.URLs {
+		res := <-results
+		emit(res.index, res.resp)
+	}
+
+	return nil
+}
+
+// Returns the image at the url resized with the given transformation. The
+// image is resized and cached first, unless it's cached already.
+func (r *Resizer) Transform(ctx context.Context, url string, t model.Transformation) ([]byte, error) {
+	imageID := genImageID(url, t)
+
+	if data, ok := r.imageCache.Get(ctx, imageID); ok {
+		return data, nil
+	}
+
+	// If the image is already being resized, wait for it rather than
+	// resizing it once more
+	owner := newBatchID()
+	if r.resizingProgress.CheckAndSetResizing(ctx, imageID, owner) {
+		if r.resizingProgress.WaitForResizingDone(ctx, imageID) == ResizingDone {
+			if data, ok := r.imageCache.Get(ctx, imageID); ok {
+				return data, nil
+			}
 		}
+	} else {
+		// the image is done even if the caller gave up on it
+		defer r.resizingProgress.DeleteResizing(context.WithoutCancel(ctx), imageID, owner)
+
+		keepCtx, stopKeeping := context.WithCancel(ctx)
+		defer stopKeeping()
+		go r.keepResizing(keepCtx, owner, []string{imageID})
+	}
+
+	if err := r.pool.Acquire(ctx); err != nil {
+		return nil, err
 	}
+	defer r.pool.Release()
 
-	return results, nil
+	src, err := r.fetchAndDecode(ctx, url)
+	if err != nil {
+		log.Printf("failed to resize %s: %v", url, err)
+		return nil, err
+	}
+
+	data, err := r.resize(ctx, src, t)
+	if err != nil {
+		log.Printf("failed to resize %s: %v", url, err)
+		return nil, err
+	}
+
+	r.cacheImage(ctx, url, imageID, data)
+
+	return data, nil
 }
 
-func (r *Resizer) ResizingProgress() *ResizingProgress {
+func (r *Resizer) ResizingProgress() ResizingProgressAdapter {
 	return r.resizingProgress
 }
 
-func (r *Resizer) processImageResize(ctx context.Context, url string, width uint, height uint) (model.ResizeResponse, error) {
-	imageID := genImageID(url, width, height)
+// Returns the store of async job records.
+func (r *Resizer) Jobs() jobs.JobStoreAdapter {
+	return r.jobs
+}
+
+// Runs a single async resize job, recording its progress and outcome in the
+// batch's job record. If the job fails with a retryable error, its failed
+// variants are retried later, with an exponential backoff, until it runs out
+// of attempts and ends up in the dead-letter store. Variants that were
+// cancelled while queued are skipped, and cancelling the job while it runs
+// aborts its fetch and resize. Returns false if the job was aborted by the
+// shutdown instead, and is to be run again once the resizer is restarted.
+func (r *Resizer) runResizeJob(job *ResizeJob) bool {
+	var cancelled map[int]bool
+	r.updateJob(context.Background(), job.BatchID, func(j *model.Job, now time.Time) {
+		cancelled = map[int]bool{}
+		for _, idx := range job.ImageIndexes {
+			if j.Images[idx].State == model.JobStateCancelled {
+				cancelled[idx] = true
+				continue
+			}
+			j.Images[idx].Start(now)
+		}
+	})
+	active := job
+	if len(cancelled) > 0 {
+		if active = activeResizeJob(job, cancelled); active == nil {
+			return true
+		}
+	}
+	job = active
+
+	ctx, cancel := context.WithCancel(r.runs)
+	defer cancel()
+	go r.watchCancellation(ctx, cancel, genImageID(job.URL, job.Transformations[0]))
+
+	imageIDs := make([]string, len(job.Transformations))
+	for i, t := range job.Transformations {
+		imageIDs[i] = genImageID(job.URL, t)
+	}
+	go r.keepResizing(ctx, job.BatchID, imageIDs)
+
+	var results []model.ResizeResponse
+	err := r.pool.Acquire(ctx)
+	if err == nil {
+		results, err = r.processImageResize(ctx, job.URL, job.Transformations)
+		r.pool.Release()
+	} else {
+		results = make([]model.ResizeResponse, len(job.Transformations))
+		for i, t := range job.Transformations {
+			results[i] = FailureResponse(err)
+			results[i].ID = genImageID(job.URL, t)
+		}
+	}
+
+	// Jobs the shutdown aborted aren't counted as attempted
+	if err != nil && r.runs.Err() != nil {
+		r.updateJob(context.Background(), job.BatchID, func(j *model.Job, now time.Time) {
+			for _, idx := range job.ImageIndexes {
+				if j.Images[idx].State == model.JobStateRunning {
+					j.Images[idx].Interrupt()
+				}
+			}
+		})
+		return false
+	}
+	job.Attempt++
+
+	// Otherwise, the job's context is only done early if the job got cancelled
+	aborted := ctx.Err() != nil
+	ctx = context.Background()
+
+	var retry *ResizeJob
+	if isRetryable(err) && !aborted {
+		if job.Attempt < r.settings.Service.RetryMaxAttempts {
+			retry = r.retryResizeJob(ctx, job, results)
+		}
+		// Jobs that can't be retried are kept, so that they can be requeued
+		if retry == nil {
+			r.addDeadLetter(ctx, job, results, err)
+		}
+	}
+
+	retryAt := time.Now().Add(r.retryDelay(job))
+	r.updateJob(ctx, job.BatchID, func(j *model.Job, now time.Time) {
+		cancelled = map[int]bool{}
+		for i, idx := range job.ImageIndexes {
+			// Cancelled images had their progress released already
+			if j.Images[idx].State == model.JobStateCancelled {
+				cancelled[idx] = true
+				continue
+			}
+			if retry != nil && results[i].Result == statusFailure {
+				j.Images[idx].Retry(results[i], retryAt)
+				continue
+			}
+			j.Images[idx].Finish(results[i], now)
+		}
+	})
+
+	// Images to be retried are still in progress
+	for i, t := range job.Transformations {
+		if cancelled[job.ImageIndexes[i]] {
+			continue
+		}
+		if retry == nil || results[i].Result != statusFailure {
+			r.resizingProgress.DeleteResizing(ctx, genImageID(job.URL, t), job.BatchID)
+		}
+	}
+
+	return true
+}
+
+// Extends the resizing locks of the owner's images every third of
+// SVC_RESIZING_LOCK_TTL, for as long as the context isn't done, so that they
+// don't expire while the images wait for a slot in the pool, or take long
+// to resize.
+func (r *Resizer) keepResizing(ctx context.Context, owner string, imageIDs []string) {
+	interval := r.settings.Service.ResizingLockTTL / 3
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 
-	// First check if the image is already cached
+		for _, imageID := range imageIDs {
+			if !r.resizingProgress.RefreshResizing(ctx, imageID, owner) {
+				log.Printf("resizing lock of %s no longer held", imageID)
+			}
+		}
+	}
+}
+
+// Extends the visibility of the received job every third of
+// SVC_QUEUE_VISIBILITY_TIMEOUT, for as long as the context isn't done, so
+// that jobs that run longer than the timeout aren't reclaimed and run twice.
+func (r *Resizer) keepReceived(ctx context.Context, job *ResizeJob) {
+	interval := r.settings.Service.QueueVisibilityTimeout / 3
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.queue.Extend(ctx, job); err != nil && ctx.Err() == nil {
+			log.Printf("failed to extend resize job of %s: %v", job.URL, err)
+		}
+	}
+}
+
+// Returns the job with its cancelled variants left out, or nil if all of
+// them are.
+func activeResizeJob(job *ResizeJob, cancelled map[int]bool) *ResizeJob {
+	active := *job
+	active.Transformations, active.ImageIndexes = nil, nil
+	for i, idx := range job.ImageIndexes {
+		if !cancelled[idx] {
+			active.Transformations = append(active.Transformations, job.Transformations[i])
+			active.ImageIndexes = append(active.ImageIndexes, idx)
+		}
+	}
+	if len(active.ImageIndexes) == 0 {
+		return nil
+	}
+
+	return &active
+}
+
+// Cancels the context of a running job once the resize of its image is
+// cancelled, on this instance or on any other. Returns once the image is
+// done, or the context is.
+func (r *Resizer) watchCancellation(ctx context.Context, cancel context.CancelFunc, imageID string) {
+	for ctx.Err() == nil {
+		switch r.resizingProgress.WaitForResizingDone(ctx, imageID) {
+		case ResizingCancelled:
+			cancel()
+			return
+		case ResizingDone:
+			return
+		}
+	}
+}
+
+// Cancels the images of the batch's job that aren't done yet. Queued images
+// are skipped once their job is received, and running ones have their fetch
+// and resize aborted. Returns the job as it is once cancelled.
+func (r *Resizer) CancelJob(ctx context.Context, batchID string) (*model.Job, error) {
+	if _, ok := r.jobs.Get(ctx, batchID); !ok {
+		return nil, jobs.ErrJobNotFound
+	}
+
+	var owned []string
+	var cancelled int
+	r.updateJob(ctx, batchID, func(job *model.Job, now time.Time) {
+		owned, cancelled = nil, 0
+		for i := range job.Images {
+			img := &job.Images[i]
+			if img.Done() {
+				continue
+			}
+			img.Cancel(now)
+			cancelled++
+			// Images resized by other jobs are left to them
+			if !img.Followed {
+				owned = append(owned, img.ID)
+			}
+		}
+	})
+	if cancelled == 0 {
+		return nil, ErrJobFinished
+	}
+
+	for _, imageID := range owned {
+		r.resizingProgress.CancelResizing(ctx, imageID, batchID)
+	}
+	log.Printf("cancelled %d images of job %s", cancelled, batchID)
+
+	job, ok := r.jobs.Get(ctx, batchID)
+	if !ok {
+		return nil, jobs.ErrJobNotFound
+	}
+
+	return job, nil
+}
+
+// Enqueues the failed variants of the job for another attempt, once the
+// backoff delay has passed. Returns nil if they can't be enqueued.
+func (r *Resizer) retryResizeJob(ctx context.Context, job *ResizeJob, results []model.ResizeResponse) *ResizeJob {
+	retry := failedResizeJob(job, results)
+
+	delay := r.retryDelay(job)
+	if err := r.queue.EnqueueAfter(ctx, retry, delay); err != nil {
+		log.Printf("failed to enqueue retry of %s: %v", job.URL, err)
+		return nil
+	}
+	log.Printf("retrying resize of %s in %v, attempt %d", job.URL, delay, job.Attempt+1)
+
+	return retry
+}
+
+// Returns the delay before the next attempt of the job.
+func (r *Resizer) retryDelay(job *ResizeJob) time.Duration {
+	return fetch.Backoff(r.settings.Service.RetryBackoff, r.settings.Service.RetryMaxBackoff, job.Attempt-1)
+}
+
+// Stores the failed variants of a job that ran out of attempts as a dead
+// letter.
+func (r *Resizer) addDeadLetter(ctx context.Context, job *ResizeJob, results []model.ResizeResponse, err error) {
+	resp := FailureResponse(err)
+	letter := &DeadLetter{
+		ID:        newBatchID(),
+		Job:       failedResizeJob(job, results),
+		Error:     resp.Error,
+		ErrorCode: resp.ErrorCode,
+		Attempts:  job.Attempt,
+		FailedAt:  time.Now(),
+	}
+
+	log.Printf("resize of %s failed after %d attempts, dead letter %s", job.URL, job.Attempt, letter.ID)
+	if err := r.deadLetters.Add(ctx, letter); err != nil {
+		log.Printf("failed to add dead letter of %s: %v", job.URL, err)
+	}
+}
+
+// Returns the store of async jobs that ran out of attempts.
+func (r *Resizer) DeadLetters() DeadLetterStoreAdapter {
+	return r.deadLetters
+}
+
+// Enqueues the job of a dead letter once more, with all of its attempts, and
+// removes the dead letter. The images of the job are queued anew in the job
+// record, if it hasn't expired yet; those another job is resizing meanwhile
+// are followed, rather than resized once more.
+func (r *Resizer) RequeueDeadLetter(ctx context.Context, id string) error {
+	letter, ok := r.deadLetters.Get(ctx, id)
+	if !ok {
+		return ErrDeadLetterNotFound
+	}
+
+	// Deleting the dead letter first makes sure it's requeued only once
+	if err := r.deadLetters.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	job := letter.Job
+	job.Attempt = 0
+	followed := r.claimJobImages(ctx, job)
+
+	r.updateJob(ctx, job.BatchID, func(j *model.Job, now time.Time) {
+		for _, idx := range job.ImageIndexes {
+			j.Images[idx].Requeue(now)
+			j.Images[idx].Followed = followed[idx]
+		}
+	})
+	r.followJobImages(job, followed)
+
+	// Images another job is resizing meanwhile are done once it is
+	if job = activeResizeJob(job, followed); job == nil {
+		return nil
+	}
+
+	if err := r.queue.Enqueue(ctx, job); err != nil {
+		resp := FailureResponse(err)
+		r.updateJob(ctx, job.BatchID, func(j *model.Job, now time.Time) {
+			for _, idx := range job.ImageIndexes {
+				j.Images[idx].Finish(resp, now)
+			}
+		})
+		for _, t := range job.Transformations {
+			r.resizingProgress.DeleteResizing(ctx, genImageID(job.URL, t), job.BatchID)
+		}
+		letter.Job = job
+		if addErr := r.deadLetters.Add(ctx, letter); addErr != nil {
+			log.Printf("failed to restore dead letter %s: %v", id, addErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Marks the images of the job as being resized by it, as ProcessAsync does.
+// Returns the indexes of the images another job is resizing already, which
+// are to be followed instead.
+func (r *Resizer) claimJobImages(ctx context.Context, job *ResizeJob) map[int]bool {
+	followed := map[int]bool{}
+	for i, t := range job.Transformations {
+		if r.resizingProgress.CheckAndSetResizing(ctx, genImageID(job.URL, t), job.BatchID) {
+			followed[job.ImageIndexes[i]] = true
+		}
+	}
+
+	return followed
+}
+
+// Follows the images of the job that are being resized by another job.
+func (r *Resizer) followJobImages(job *ResizeJob, followed map[int]bool) {
+	for i, t := range job.Transformations {
+		if idx := job.ImageIndexes[i]; followed[idx] {
+			following := *job
+			following.Transformations, following.ImageIndexes = []model.Transformation{t}, []int{idx}
+			go r.followJobImage(&following)
+		}
+	}
+}
+
+// Waits for the only image of the job, that's being resized by another job,
+// to be done, and records whether it got resized. If the other job's batch
+// cancels the resize, the job takes it over, and is enqueued to resize the
+// image itself, unless yet another job did first.
+func (r *Resizer) followJobImage(job *ResizeJob) {
+	ctx := context.Background()
+	idx, imageID := job.ImageIndexes[0], genImageID(job.URL, job.Transformations[0])
+
+	// The wait times out after a while, so it's repeated for as long as the
+	// other job takes
+	outcome := ResizingTimedOut
+	for outcome != ResizingDone {
+		outcome = r.resizingProgress.WaitForResizingDone(ctx, imageID)
+		if outcome == ResizingCancelled && r.takeOverJobImage(ctx, job) {
+			return
+		}
+	}
+
+	resp := FailureResponse(errConcurrentResizeFailed)
 	if r.imageCache.Contains(ctx, imageID) {
-		return model.ResizeResponse{ID: imageID, Result: statusSuccess, Cached: true}, nil
+		resp = model.ResizeResponse{Result: statusSuccess, Cached: true}
+	}
+	resp.ID = imageID
+
+	r.updateJob(ctx, job.BatchID, func(j *model.Job, now time.Time) {
+		// the job may have been cancelled meanwhile
+		if !j.Images[idx].Done() {
+			j.Images[idx].Finish(resp, now)
+		}
+	})
+}
+
+// Marks the only image of the job as being resized by it, and enqueues the
+// job, once the resize it followed got cancelled by another batch. Returns
+// false if another job is resizing the image already; returns true once the
+// job is done with the image otherwise, including when the job's own batch
+// cancelled it meanwhile.
+func (r *Resizer) takeOverJobImage(ctx context.Context, job *ResizeJob) bool {
+	idx, imageID := job.ImageIndexes[0], genImageID(job.URL, job.Transformations[0])
+	if r.resizingProgress.CheckAndSetResizing(ctx, imageID, job.BatchID) {
+		return false
+	}
+
+	// Once it's no longer followed, cancelling the batch cancels its resize
+	var done bool
+	r.updateJob(ctx, job.BatchID, func(j *model.Job, _ time.Time) {
+		done = j.Images[idx].Done()
+		if !done {
+			j.Images[idx].Followed = false
+		}
+	})
+	if done {
+		r.resizingProgress.DeleteResizing(ctx, imageID, job.BatchID)
+		return true
+	}
+
+	log.Printf("resize of %s cancelled by another batch, taken over by job %s", imageID, job.BatchID)
+	if err := r.queue.Enqueue(ctx, job); err != nil {
+		resp := FailureResponse(err)
+		resp.ID = imageID
+		r.updateJob(ctx, job.BatchID, func(j *model.Job, now time.Time) {
+			if !j.Images[idx].Done() {
+				j.Images[idx].Finish(resp, now)
+			}
+		})
+		r.resizingProgress.DeleteResizing(ctx, imageID, job.BatchID)
 	}
 
-	// Retrieve the image from the url
-	data, err := r.fetchAndResize(ctx, url, width, height)
+	return true
+}
+
+// Updates the job record. Once the update gets all images of the job done,
+// the job's results are delivered to its callback URL, if it has one.
+func (r *Resizer) updateJob(ctx context.Context, batchID string, update func(job *model.Job, now time.Time)) {
+	var done *model.Job
+	err := r.jobs.Update(ctx, batchID, func(job *model.Job) {
+		// stores may run the update more than once
+		done = nil
+		now := time.Now()
+		wasDone := job.FinishedAt != nil
+		update(job, now)
+		job.UpdateState(now)
+		if !wasDone && job.FinishedAt != nil && job.Callback != nil {
+			done = job.Clone()
+		}
+	})
 	if err != nil {
-		log.Printf("failed to resize %s: %v", url, err)
-		return model.ResizeResponse{Result: statusFailure}, err
+		log.Printf("failed to update job %s: %v", batchID, err)
+		return
+	}
+
+	if done != nil {
+		r.callbacks.Go(func() { r.deliverCallback(done) })
+	}
+}
+
+// Posts the results of the job to its callback URL, recording each delivery
+// attempt in the job record.
+func (r *Resizer) deliverCallback(job *model.Job) {
+	ctx := context.Background()
+
+	err := r.notifier.Deliver(r.deliveries, job.Callback.URL, job.CallbackPayload(), func(attempt model.CallbackAttempt) {
+		r.updateJob(ctx, job.BatchID, func(j *model.Job, _ time.Time) {
+			j.Callback.Attempts = append(j.Callback.Attempts, attempt)
+		})
+	})
+
+	state := model.CallbackStateDelivered
+	if err != nil {
+		log.Printf("failed to deliver results of job %s: %v", job.BatchID, err)
+		state = model.CallbackStateFailed
+	}
+	r.updateJob(ctx, job.BatchID, func(j *model.Job, _ time.Time) {
+		j.Callback.State = state
+	})
+}
+
+// Resizes a single image of a synchronous batch, once a slot in the pool is
+// free.
+func (r *Resizer) processBatchImage(ctx context.Context, url string, transformations []model.Transformation, hasVariants bool) model.ResizeResponse {
+	if err := r.pool.Acquire(ctx); err != nil {
+		variants := make([]model.ResizeResponse, len(transformations))
+		for i, t := range transformations {
+			variants[i] = FailureResponse(err)
+			variants[i].ID = genImageID(url, t)
+		}
+		return newResizeResponse(url, variants, hasVariants)
+	}
+	defer r.pool.Release()
+
+	variants, _ := r.processImageResize(ctx, url, transformations)
+	return newResizeResponse(url, variants, hasVariants)
+}
+
+// Resizes the image at the url into each of the given variants, fetching
+// and decoding the source image at most once. Returns one response per
+// variant, in the same order.
+func (r *Resizer) processImageResize(ctx context.Context, url string, transformations []model.Transformation) ([]model.ResizeResponse, error) {
+	results := make([]model.ResizeResponse, len(transformations))
+	var src *sourceImage
+	var srcErr, resizeErr error
+
+	for i, t := range transformations {
+		imageID := genImageID(url, t)
+
+		// First check if the image is already cached
+		if r.imageCache.Contains(ctx, imageID) {
+			results[i] = model.ResizeResponse{ID: imageID, Result: statusSuccess, Cached: true}
+			continue
+		}
+
+		// Retrieve and decode the image from the url, once for all variants
+		if src == nil && srcErr == nil {
+			src, srcErr = r.fetchAndDecode(ctx, url)
+			if srcErr != nil {
+				log.Printf("failed to resize %s: %v", url, srcErr)
+			}
+		}
+		if srcErr != nil {
+			results[i] = FailureResponse(srcErr)
+			results[i].ID = imageID
+			continue
+		}
+
+		data, err := r.resize(ctx, src, t)
+		if err != nil {
+			log.Printf("failed to resize %s: %v", url, err)
+			results[i] = FailureResponse(err)
+			results[i].ID = imageID
+			resizeErr = err
+			continue
+		}
+
+		r.cacheImage(ctx, url, imageID, data)
+
+		results[i] = model.ResizeResponse{ID: imageID, Result: statusSuccess, Cached: false}
 	}
 
+	return results, errors.Join(srcErr, resizeErr)
+}
+
+// Caches the image, indexing it by its source URL, so that it can be purged
+// along with the other images derived from it.
+func (r *Resizer) cacheImage(ctx context.Context, url string, imageID string, data []byte) {
 	log.Print("caching ", imageID)
 	r.imageCache.Add(ctx, imageID, data)
 
-	return model.ResizeResponse{ID: imageID, Result: statusSuccess, Cached: false}, nil
+	if err := r.sourceIndex.Add(ctx, url, imageID); err != nil {
+		log.Printf("failed to index %s by source %s: %v", imageID, url, err)
+	}
 }
 
-func (r *Resizer) fetchAndResize(ctx context.Context, url string, width uint, height uint) ([]byte, error) {
+func (r *Resizer) fetchAndDecode(ctx context.Context, url string) (*sourceImage, error) {
 	data, err := r.fetch(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 
-	return r.resize(data, width, height)
+	return r.decode(ctx, data)
 }
 
 func (r *Resizer) fetch(ctx context.Context, url string) ([]byte, error) {
+	data, _, err := r.fetchSource(ctx, url, model.SourceVersion{})
+	return data, err
+}
+
+// Fetches the source image at url, conditionally on the validators of the
+// given version, if it has any. Returns the image along with the validators
+// of the version fetched, or no image if the origin reports that it's not
+// modified.
+func (r *Resizer) fetchSource(ctx context.Context, url string, version model.SourceVersion) ([]byte, model.SourceVersion, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, version, fmt.Errorf("%w: %w", ErrFetchFailed, err)
 	}
 	req.Header.Set("User-Agent", r.settings.Http.ClientUserAgent)
+	if version.ETag != "" {
+		req.Header.Set("If-None-Match", version.ETag)
+	}
+	if version.LastModified != "" {
+		req.Header.Set("If-Modified-Since", version.LastModified)
+	}
 	log.Print("fetching ", url)
-	res, err := new(http.Client).Do(req)
+	res, err := r.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("image fetch failed: %v", err)
+		return nil, version, fmt.Errorf("%w: %w", ErrFetchFailed, err)
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotModified && (version.ETag != "" || version.LastModified != "") {
+		return nil, version, nil
+	}
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("non-200 status: %d", res.StatusCode)
+		return nil, version, &fetch.StatusError{StatusCode: res.StatusCode}
+	}
+
+	maxSize := r.settings.Service.MaxImageSize
+	if res.ContentLength > maxSize {
+		return nil, version, fmt.Errorf("%w: %d bytes exceed %d", ErrImageTooLarge, res.ContentLength, maxSize)
 	}
 
-	data, err := io.ReadAll(io.LimitReader(res.Body, r.settings.Service.MaxImageSize))
+	// Read one byte past the limit, to tell images that are too large from
+	// those that just fit
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxSize+1))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read image data: %v", err)
+		return nil, version, fmt.Errorf("%w: failed to read image data: %w", ErrFetchFailed, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, version, fmt.Errorf("%w: exceeds %d bytes", ErrImageTooLarge, maxSize)
 	}
 
-	return data, nil
+	fetched := model.SourceVersion{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}
+	return data, fetched, nil
 }
 
-func (r *Resizer) resize(data []byte, width uint, height uint) ([]byte, error) {
-	// decode jpeg into image.Image
-	img, err := jpeg.Decode(bytes.NewReader(data))
+func (r *Resizer) decode(ctx context.Context, data []byte) (*sourceImage, error) {
+	if err := r.cpu.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer r.cpu.Release()
+
+	// sniff the source format and check its dimensions before decoding it
+	config, format, err := r.formats.DecodeConfig(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode jpeg: %v", err)
+		return nil, err
 	}
+	if err := checkSourceLimits(r.settings, config); err != nil {
+		return nil, err
+	}
+
+	// decode the image into image.Image
+	img, err := format.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrDecodeFailed, format.Name, err)
+	}
+
+	// turn the image upright before resizing it
+	orientation := 1
+	if format.Name == "jpeg" {
+		orientation = exifOrientation(data)
+		img = orientImage(img, orientation)
+	}
+
+	return &sourceImage{data: data, img: img, format: format, orientation: orientation}, nil
+}
+
+func (r *Resizer) resize(ctx context.Context, src *sourceImage, t model.Transformation) ([]byte, error) {
+	// bound what's allocated by resizing, not just what's requested
+	width, height := fitDimensions(src.img.Bounds().Size(), t)
+	if err := checkResizeLimits(r.settings, width, height); err != nil {
+		return nil, err
+	}
+
+	if err := r.cpu.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer r.cpu.Release()
 
 	// if either width or height is 0, it will resize respecting the aspect ratio
-	newImage := jpgresize.Resize(width, height, img, jpgresize.Lanczos3)
+	newImage := fitImage(src.img, t, backgroundColor(t))
 
-	newData := bytes.Buffer{}
-	err = jpeg.Encode(bufio.NewWriter(&newData), newImage, nil)
+	newData, err := r.encode(newImage, t)
+	if err != nil {
+		return nil, err
+	}
+
+	// carry over the source metadata if requested, JPEG to JPEG only
+	if t.Metadata == metadataKeep && src.format.Name == "jpeg" && outputFormat(t) == "jpeg" {
+		newData = injectSegments(newData, extractMetadata(src.data, src.orientation != 1))
+	}
+
+	return newData, nil
+}
+
+func (r *Resizer) encode(img goimage.Image, t model.Transformation) ([]byte, error) {
+	format, err := r.formats.LookupEncoder(outputFormat(t))
 	if err != nil {
-		return nil, fmt.Errorf("failed to jpeg encode resized image: %v", err)
+		return nil, err
+	}
+
+	newData := bytes.Buffer{}
+	if err := format.Encode(&newData, img, t.Quality); err != nil {
+		return nil, fmt.Errorf("failed to %s encode resized image: %v", format.Name, err)
 	}
 
 	return newData.Bytes(), nil
 }
 
-func (r *Resizer) trySendResizeJob(url string, width uint, height uint) bool {
+func (r *Resizer) trySendResizeJob(ctx context.Context, batchID string, url string, priority string, transformations []model.Transformation, imageIndexes []int) error {
 	// Enqueue async resize job
-	job := &ResizeJob{URL: url, Width: width, Height: height}
+	job := &ResizeJob{BatchID: batchID, URL: url, Priority: priority, Transformations: transformations, ImageIndexes: imageIndexes}
 
-	select {
-	case r.resizeJobs <- job:
-		return true
-	default:
-		return false
+	err := r.queue.Enqueue(ctx, job)
+	if err != nil && !errors.Is(err, ErrQueueFull) && !errors.Is(err, ErrQueueClosed) {
+		return fmt.Errorf("%w: %v", ErrQueueUnavailable, err)
+	}
+
+	return err
+}
+
+// Estimates how long it takes the workers to get through the jobs in the
+// queue, from the queue's depth and the rate the workers finished jobs at
+// lately.
+func (r *Resizer) retryAfter(ctx context.Context) time.Duration {
+	depth, err := r.queue.Len(ctx)
+	if err != nil {
+		return maxRetryAfter
+	}
+
+	rate := r.throughput.Rate(time.Now())
+	if rate <= 0 {
+		// Until jobs get finished, each worker is taken to finish one a second
+		rate = float64(max(1, r.Workers()))
+	}
+
+	delay := time.Duration(float64(depth) / rate * float64(time.Second))
+	return min(max(delay, minRetryAfter), maxRetryAfter)
+}
+
+func resizeWorkers(settings *settings.Settings) int {
+	if settings.Service.ResizeWorkers > 0 {
+		return min(settings.Service.ResizeWorkers, maxResizeJobWorkers)
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+func resizeQueueSize(settings *settings.Settings) int {
+	if settings.Service.ResizeQueueSize > 0 {
+		return settings.Service.ResizeQueueSize
 	}
+
+	return resizeJobsPerCPU * runtime.GOMAXPROCS(0)
 }
 
-func genImageID(url string, width uint, height uint) string {
-	sha := sha256.Sum256([]byte(fmt.Sprintf("%s,%d,%d", url, width, height)))
+func cpuConcurrency(settings *settings.Settings) int {
+	if settings.Service.CPUConcurrency > 0 {
+		return settings.Service.CPUConcurrency
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// Builds the response for a single source url. Without variants in the
+// request, the response of the only variant is returned as is; otherwise
+// the variant responses are nested, in the same order as in the request.
+func newResizeResponse(url string, variants []model.ResizeResponse, hasVariants bool) model.ResizeResponse {
+	if !hasVariants {
+		resp := variants[0]
+		resp.URL = url
+		return resp
+	}
+
+	resp := model.ResizeResponse{URL: url, Result: statusSuccess, Variants: variants}
+	for _, variant := range variants {
+		switch {
+		case variant.Result == statusFailure && resp.Result != statusFailure:
+			// report the first failure on the response itself
+			resp.Result = statusFailure
+			resp.Error, resp.ErrorCode, resp.OriginStatus = variant.Error, variant.ErrorCode, variant.OriginStatus
+		case variant.Result == statusEnqueued && resp.Result == statusSuccess:
+			resp.Result = statusEnqueued
+		}
+	}
+
+	return resp
+}
+
+// Returns a copy of the job with only the variants that failed.
+func failedResizeJob(job *ResizeJob, results []model.ResizeResponse) *ResizeJob {
+	failed := &ResizeJob{BatchID: job.BatchID, URL: job.URL, Priority: job.Priority, Attempt: job.Attempt}
+	for i, resp := range results {
+		if resp.Result == statusFailure {
+			failed.Transformations = append(failed.Transformations, job.Transformations[i])
+			failed.ImageIndexes = append(failed.ImageIndexes, job.ImageIndexes[i])
+		}
+	}
+
+	return failed
+}
+
+// Generates a random ID for a batch of async resizes.
+func newBatchID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Generates an ID for the image resized from the url with the given options.
+// Options left at their defaults are not part of the key, so images resized
+// before those options existed keep their IDs.
+func genImageID(url string, t model.Transformation) string {
+	key := fmt.Sprintf("%s,%d,%d", url, t.Width, t.Height)
+	if format := outputFormat(t); format != defaultOutputFormat {
+		key += ",format=" + format
+	}
+	if t.Quality != 0 {
+		key += fmt.Sprintf(",quality=%d", t.Quality)
+	}
+	if t.Fit != "" && t.Fit != fitFill {
+		key += ",fit=" + t.Fit
+	}
+	if t.Gravity != "" && t.Gravity != gravityCenter {
+		key += ",gravity=" + t.Gravity
+	}
+	if t.Metadata == metadataKeep {
+		key += ",metadata=" + t.Metadata
+	}
+
+	sha := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(sha[:])
 }
@@ -3,13 +3,23 @@ package image
 import (
 	"context"
 
+	"github.com/okulik/img-resize/internal/jobs"
 	"github.com/okulik/img-resize/internal/model"
 )
 
 type ImageResizer interface {
 	Start()
 	Shutdown()
+	Workers() int
+	SetWorkers(n int) error
 	Process(request *model.ResizeRequest, ctx context.Context) ([]model.ResizeResponse, error)
+	ProcessStream(request *model.ResizeRequest, ctx context.Context, emit func(int, model.ResizeResponse)) error
 	ProcessAsync(request *model.ResizeRequest) []model.ResizeResponse
-	ResizingProgress() *ResizingProgress
+	Transform(ctx context.Context, url string, t model.Transformation) ([]byte, error)
+	PurgeSource(ctx context.Context, url string) (int, error)
+	ResizingProgress() ResizingProgressAdapter
+	Jobs() jobs.JobStoreAdapter
+	CancelJob(ctx context.Context, batchID string) (*model.Job, error)
+	DeadLetters() DeadLetterStoreAdapter
+	RequeueDeadLetter(ctx context.Context, id string) error
 }
@@ -0,0 +1,295 @@
+package image_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	goimage "image"
+	_ "image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+func TestResizerProcessFits(t *testing.T) {
+	server := buildImageServer(t, 400, 200)
+	defer server.Close()
+
+	tests := []struct {
+		fit    string
+		width  int
+		height int
+	}{
+		{"fill", 100, 100},
+		{"cover", 100, 100},
+		{"contain", 100, 100},
+		{"inside", 100, 50},
+		{"outside", 200, 100},
+	}
+
+	for _, test := range tests {
+		imageCache, _ := cache.NewLRUImageCache(10)
+		resizer := image.NewResizer(buildSettings(), imageCache)
+
+		request := &model.ResizeRequest{
+			URLs:           []string{server.URL},
+			Transformation: model.Transformation{Width: 100, Height: 100, Format: "png", Fit: test.fit},
+		}
+		img := processAndDecode(t, resizer, imageCache, request)
+
+		if img.Bounds().Dx() != test.width || img.Bounds().Dy() != test.height {
+			t.Errorf("unexpected bounds for %s fit: %v", test.fit, img.Bounds())
+		}
+	}
+}
+
+func TestResizerProcessGravity(t *testing.T) {
+	server := buildImageServer(t, 400, 200)
+	defer server.Close()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+
+	ids := map[string]bool{}
+	for _, gravity := range []string{"west", "east"} {
+		request := &model.ResizeRequest{
+			URLs:           []string{server.URL},
+			Transformation: model.Transformation{Width: 100, Height: 100, Format: "png", Fit: "cover", Gravity: gravity},
+		}
+		for _, resp := range resizer.ProcessAsync(request) {
+			ids[resp.ID] = true
+		}
+	}
+
+	if len(ids) != 2 {
+		t.Errorf("expected each gravity to get its own image id, got: %v", ids)
+	}
+}
+
+func TestResizerProcessVariants(t *testing.T) {
+	fetches := 0
+	data := encodeTestPNG(t, 400, 200)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches++
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+
+	request := &model.ResizeRequest{
+		URLs:           []string{server.URL},
+		Transformation: model.Transformation{Format: "png"},
+		Variants:       []model.Transformation{{Width: 100}, {Width: 200}, {Width: 300, Format: "gif"}},
+	}
+	responses, err := resizer.Process(request, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fetches != 1 {
+		t.Errorf("expected source image to be fetched once, got: %d", fetches)
+	}
+
+	if len(responses) != 1 || responses[0].URL != server.URL || len(responses[0].Variants) != 3 {
+		t.Fatalf("unexpected responses: %v", responses)
+	}
+
+	for i, width := range []int{100, 200, 300} {
+		resized, ok := imageCache.Get(context.Background(), responses[0].Variants[i].ID)
+		if !ok {
+			t.Fatalf("variant %d not cached", i)
+		}
+
+		config, _, err := goimage.DecodeConfig(bytes.NewReader(resized))
+		if err != nil || config.Width != width {
+			t.Errorf("unexpected variant %d width: %d, %v", i, config.Width, err)
+		}
+	}
+}
+
+func processAndDecode(t *testing.T, resizer *image.Resizer, imageCache cache.ImageCacheAdapter, request *model.ResizeRequest) goimage.Image {
+	t.Helper()
+
+	responses := resizer.ProcessAsync(request)
+	resizer.Start()
+	resizer.Shutdown()
+
+	data, ok := imageCache.Get(context.Background(), responses[0].ID)
+	if !ok {
+		t.Fatalf("resized image not cached")
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode resized image: %v", err)
+	}
+
+	return img
+}
+
+func buildImageServer(t *testing.T, width int, height int) *httptest.Server {
+	data := encodeTestPNG(t, width, height)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(data)
+	}))
+}
+
+func encodeTestPNG(t *testing.T, width int, height int) []byte {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, buildTestImage(width, height)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func buildSettings() *settings.Settings {
+	return &settings.Settings{
+		Service: &settings.ServiceSettings{
+			AsyncResize:        true,
+			ImageResizeTimeout: time.Second,
+			MaxImageSize:       1 << 20,
+		},
+		Auth: &settings.AuthSettings{},
+		Http: &settings.HttpSettings{
+			// test images are served from the loopback interface
+			ClientAllowPrivateNetworks: true,
+		},
+	}
+}
+
+func TestResizerProcessInParallel(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	data := encodeTestPNG(t, 40, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	settings := buildSettings()
+	settings.Service.Concurrency = 2
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(settings, imageCache)
+
+	urls := []string{}
+	for i := 0; i < 6; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d.png", server.URL, i))
+	}
+
+	responses, err := resizer.Process(&model.ResizeRequest{URLs: urls, Transformation: model.Transformation{Width: 10}}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, resp := range responses {
+		if resp.URL != urls[i] || resp.Result != "success" {
+			t.Errorf("unexpected response %d: %v", i, resp)
+		}
+	}
+
+	if maxRunning.Load() != 2 {
+		t.Errorf("expected 2 images to be fetched at once, got: %d", maxRunning.Load())
+	}
+}
+
+func TestResizerProcessCancelled(t *testing.T) {
+	server := buildImageServer(t, 40, 20)
+	defer server.Close()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	responses, _ := resizer.Process(&model.ResizeRequest{URLs: []string{server.URL}}, ctx)
+	if len(responses) != 1 || responses[0].ErrorCode != model.ErrorCodeTimeout {
+		t.Errorf("unexpected responses: %v", responses)
+	}
+}
+
+func TestResizerProcessStream(t *testing.T) {
+	server := buildImageServer(t, 40, 20)
+	defer server.Close()
+
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizer(buildSettings(), imageCache)
+
+	urls := []string{server.URL + "/a.png", server.URL + "/b.png", server.URL + "/c.png"}
+	emitted := map[int]model.ResizeResponse{}
+	err := resizer.ProcessStream(&model.ResizeRequest{URLs: urls, Transformation: model.Transformation{Width: 10}}, context.Background(), func(i int, resp model.ResizeResponse) {
+		emitted[i] = resp
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(emitted) != len(urls) {
+		t.Fatalf("expected a response per url, got: %v", emitted)
+	}
+
+	for i, url := range urls {
+		if emitted[i].URL != url || emitted[i].Result != "success" {
+			t.Errorf("unexpected response %d: %v", i, emitted[i])
+		}
+	}
+}
+
+func TestResizerTransformKeepsResizingLock(t *testing.T) {
+	data := encodeTestPNG(t, 40, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	s := buildSettings()
+	s.Service.ResizingLockTTL = 30 * time.Millisecond
+	progress := &refreshCountingProgress{ResizingProgressAdapter: image.NewLocalResizingProgress(s)}
+	imageCache, _ := cache.NewLRUImageCache(10)
+	resizer := image.NewResizerWithBackends(s, imageCache, image.ResizerBackends{
+		Queue:            image.NewMemoryJobQueue(s),
+		ResizingProgress: progress,
+		SourceIndex:      cache.NewMemorySourceIndex(10),
+	})
+
+	if _, err := resizer.Transform(context.Background(), server.URL, model.Transformation{Width: 20}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the lock is extended every 10ms while the source is fetched
+	if n := progress.refreshed.Load(); n < 5 {
+		t.Errorf("expected the resizing lock to be refreshed, got %d refreshes", n)
+	}
+}
+
+// A resizing progress that counts the times it's refreshed.
+type refreshCountingProgress struct {
+	image.ResizingProgressAdapter
+	refreshed atomic.Int32
+}
+
+func (p *refreshCountingProgress) RefreshResizing(ctx context.Context, imageID string, owner string) bool {
+	p.refreshed.Add(1)
+	return p.ResizingProgressAdapter.RefreshResizing(ctx, imageID, owner)
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -8,35 +9,74 @@ import (
 	"github.com/okulik/img-resize/internal/settings"
 )
 
-type ResizingProgress struct {
-	settings *settings.Settings
-	resizing map[string]chan struct{}
-	mu       sync.RWMutex
+// Outcomes of waiting for an image to be resized.
+type ResizingOutcome int
+
+const (
+	// The image was resized, or wasn't being resized at all.
+	ResizingDone ResizingOutcome = iota
+	// The image is still being resized, but the wait timed out.
+	ResizingTimedOut
+	// The resize of the image was cancelled.
+	ResizingCancelled
+)
+
+// ResizingProgressAdapter keeps track of the images being resized, so that
+// an image is resized only once at a time, and so that callers can wait for
+// an image that's being resized. An image is marked by its owner, a token
+// unique to whoever resizes it, and only the owner may extend, release or
+// cancel the mark.
+type ResizingProgressAdapter interface {
+	CheckAndSetResizing(ctx context.Context, imageID string, owner string) bool
+	CheckResizing(ctx context.Context, imageID string) bool
+	RefreshResizing(ctx context.Context, imageID string, owner string) bool
+	DeleteResizing(ctx context.Context, imageID string, owner string)
+	CancelResizing(ctx context.Context, imageID string, owner string)
+	WaitForResizingDone(ctx context.Context, imageID string) ResizingOutcome
+}
+
+// LocalResizingProgress keeps track of the images being resized by this
+// process only.
+type LocalResizingProgress struct {
+	settings  *settings.Settings
+	resizing  map[string]*resizing
+	cancelled map[string]time.Time // when images whose resize was cancelled were released
+	mu        sync.RWMutex
 }
 
-func NewResizingProgress(settings *settings.Settings) *ResizingProgress {
-	return &ResizingProgress{
-		settings: settings,
-		resizing: make(map[string]chan struct{}),
+// An image being resized. Done is closed once it's no longer resized, after
+// cancelled tells why.
+type resizing struct {
+	owner     string
+	done      chan struct{}
+	cancelled bool
+}
+
+func NewLocalResizingProgress(settings *settings.Settings) ResizingProgressAdapter {
+	return &LocalResizingProgress{
+		settings:  settings,
+		resizing:  make(map[string]*resizing),
+		cancelled: make(map[string]time.Time),
 	}
 }
 
-// Atomically checks if an image is being resized and marks it as in progress.
-func (rp *ResizingProgress) CheckAndSetResizing(imageID string) bool {
+// Atomically checks if an image is being resized by another owner, and marks
+// it as in progress by the owner otherwise.
+func (rp *LocalResizingProgress) CheckAndSetResizing(_ context.Context, imageID string, owner string) bool {
 	rp.mu.Lock()
 	defer rp.mu.Unlock()
 
-	if _, ok := rp.resizing[imageID]; ok {
-		return true
+	if res, ok := rp.resizing[imageID]; ok {
+		return res.owner != owner
 	}
 
-	rp.resizing[imageID] = make(chan struct{})
+	rp.resizing[imageID] = &resizing{owner: owner, done: make(chan struct{})}
 
 	return false
 }
 
 // Checks if an image is being resized.
-func (rp *ResizingProgress) CheckResizing(imageID string) bool {
+func (rp *LocalResizingProgress) CheckResizing(_ context.Context, imageID string) bool {
 	rp.mu.RLock()
 	defer rp.mu.RUnlock()
 
@@ -44,48 +84,80 @@ func (rp *ResizingProgress) CheckResizing(imageID string) bool {
 	return ok
 }
 
-// Marks the image as being resized.
-func (rp *ResizingProgress) SetResizing(imageID string) {
-	rp.mu.Lock()
-	defer rp.mu.Unlock()
+// Reports whether the image is still marked as being resized by the owner.
+// Marks kept in memory don't expire, so there's nothing to extend.
+func (rp *LocalResizingProgress) RefreshResizing(_ context.Context, imageID string, owner string) bool {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
 
-	rp.resizing[imageID] = make(chan struct{})
+	res, ok := rp.resizing[imageID]
+	return ok && res.owner == owner
 }
 
-// Unmarks the image as being resized.
-func (rp *ResizingProgress) DeleteResizing(imageID string) {
-	rp.mu.RLock()
-	ch, ok := rp.resizing[imageID]
-	if !ok {
-		rp.mu.RUnlock()
-		return
-	}
-	close(ch)
-	rp.mu.RUnlock()
+// Unmarks the image as being resized, if the owner marked it.
+func (rp *LocalResizingProgress) DeleteResizing(_ context.Context, imageID string, owner string) {
+	rp.release(imageID, owner, false)
+}
 
-	rp.mu.Lock()
-	delete(rp.resizing, imageID)
-	rp.mu.Unlock()
+// Unmarks the image as being resized, if the owner marked it, releasing its
+// waiters with the cancelled outcome.
+func (rp *LocalResizingProgress) CancelResizing(_ context.Context, imageID string, owner string) {
+	rp.release(imageID, owner, true)
 }
 
 // Synchronously blocks until the image has been resized or the timer expires,
 // whichever happens first.
-func (rp *ResizingProgress) WaitForResizingDone(imageID string) bool {
+func (rp *LocalResizingProgress) WaitForResizingDone(ctx context.Context, imageID string) ResizingOutcome {
 	rp.mu.RLock()
-	defer rp.mu.RUnlock()
-
-	ch, ok := rp.resizing[imageID]
+	res, ok := rp.resizing[imageID]
+	cancelledAt, cancelled := rp.cancelled[imageID]
+	rp.mu.RUnlock()
 	if !ok {
-		return true
+		// Waiters that come after the cancel tell it by its marker
+		if cancelled && time.Since(cancelledAt) < resizingCancelledTTL {
+			return ResizingCancelled
+		}
+		return ResizingDone
 	}
 
 	log.Printf("waiting for resize of %s to finish", imageID)
 
 	select {
-	case <-ch:
+	case <-res.done:
+	case <-ctx.Done():
+		return ResizingTimedOut
 	case <-time.After(rp.settings.Service.ImageResizeTimeout):
-		return false
+		return ResizingTimedOut
 	}
 
-	return true
+	if res.cancelled {
+		return ResizingCancelled
+	}
+
+	return ResizingDone
+}
+
+func (rp *LocalResizingProgress) release(imageID string, owner string, cancelled bool) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	res, ok := rp.resizing[imageID]
+	if !ok || res.owner != owner {
+		return
+	}
+	res.cancelled = cancelled
+	close(res.done)
+	delete(rp.resizing, imageID)
+
+	// A resize that's done supersedes an earlier cancel
+	delete(rp.cancelled, imageID)
+	if cancelled {
+		now := time.Now()
+		for id, cancelledAt := range rp.cancelled {
+			if now.Sub(cancelledAt) >= resizingCancelledTTL {
+				delete(rp.cancelled, id)
+			}
+		}
+		rp.cancelled[imageID] = now
+	}
 }
@@ -0,0 +1,24 @@
+package image
+
+import "context"
+
+// semaphore bounds the number of goroutines doing some work at once.
+type semaphore chan struct{}
+
+func newSemaphore(size int) semaphore {
+	return make(semaphore, max(1, size))
+}
+
+// Blocks until a slot is free or the context is done.
+func (s semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) Release() {
+	<-s
+}
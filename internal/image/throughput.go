@@ -0,0 +1,70 @@
+package image
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// The moving average of the throughput is updated every throughputTick, and
+// averages over roughly the last throughputWindow.
+const (
+	throughputTick   = 5 * time.Second
+	throughputWindow = time.Minute
+)
+
+// throughputMeter keeps an exponentially weighted moving average of the
+// number of jobs finished per second, much like the load average does for
+// the number of running processes.
+type throughputMeter struct {
+	rate     float64
+	count    int // jobs finished since the last tick
+	lastTick time.Time
+	seeded   bool
+	mu       sync.Mutex
+}
+
+func newThroughputMeter(now time.Time) *throughputMeter {
+	return &throughputMeter{lastTick: now}
+}
+
+// Records a finished job.
+func (m *throughputMeter) Mark(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tick(now)
+	m.count++
+}
+
+// Returns the moving average of jobs finished per second.
+func (m *throughputMeter) Rate(now time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tick(now)
+	return m.rate
+}
+
+// Folds the jobs finished since the last tick into the average, along with
+// any ticks without finished jobs since.
+func (m *throughputMeter) tick(now time.Time) {
+	ticks := int(now.Sub(m.lastTick) / throughputTick)
+	if ticks == 0 {
+		return
+	}
+
+	alpha := 1 - math.Exp(-throughputTick.Seconds()/throughputWindow.Seconds())
+	rate := float64(m.count) / throughputTick.Seconds()
+	if m.seeded {
+		m.rate += alpha * (rate - m.rate)
+	} else if m.count > 0 {
+		// The first jobs finished set the average, rather than creep it up
+		m.rate = rate
+		m.seeded = true
+	}
+	m.rate *= math.Pow(1-alpha, float64(ticks-1))
+
+	m.count = 0
+	m.lastTick = m.lastTick.Add(time.Duration(ticks) * throughputTick)
+}
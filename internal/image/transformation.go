@@ -0,0 +1,215 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/okulik/img-resize/internal/fetch"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+const defaultOutputFormat = "jpeg"
+
+// Resolves the presets a resize request refers to, then validates its
+// transformation options and normalizes them in place, so that equivalent
+// requests map to the same image IDs.
+func ValidateResizeRequest(settings *settings.Settings, request *model.ResizeRequest) error {
+	if err := prepareTransformation(settings, &request.Transformation); err != nil {
+		return err
+	}
+
+	for i := range request.Variants {
+		if err := prepareTransformation(settings, &request.Variants[i]); err != nil {
+			return fmt.Errorf("variant %d: %v", i, err)
+		}
+	}
+
+	if request.CallbackURL != "" {
+		if err := validateCallbackURL(settings, request.CallbackURL); err != nil {
+			return err
+		}
+	}
+
+	request.Priority = strings.ToLower(request.Priority)
+	if request.Priority != "" && !slices.Contains(priorities, request.Priority) {
+		return fmt.Errorf("priority must be one of %s", strings.Join(priorities, ", "))
+	}
+
+	return nil
+}
+
+// Validates the source URL and the variants of a schedule, resolving and
+// normalizing the variants in place, like those of a resize request.
+func ValidateSchedule(settings *settings.Settings, schedule *model.Schedule) error {
+	u, err := url.Parse(schedule.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if err := fetch.CheckURL(settings, u); err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+
+	if len(schedule.Variants) == 0 {
+		return errors.New("at least one variant is required")
+	}
+	for i := range schedule.Variants {
+		if err := prepareTransformation(settings, &schedule.Variants[i]); err != nil {
+			return fmt.Errorf("variant %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Callbacks are signed with the webhook secret, so they're only allowed once
+// a secret is set. Callback URLs are subject to the same origin restrictions
+// as source image URLs.
+func validateCallbackURL(settings *settings.Settings, callbackURL string) error {
+	if settings.Service.WebhookSecret == "" {
+		return errors.New("callbacks are not enabled")
+	}
+
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback url: %v", err)
+	}
+	if err := fetch.CheckURL(settings, u); err != nil {
+		return fmt.Errorf("invalid callback url: %v", err)
+	}
+
+	return nil
+}
+
+func prepareTransformation(settings *settings.Settings, t *model.Transformation) error {
+	if t.Preset != "" {
+		preset, ok := settings.Service.Presets[t.Preset]
+		if !ok {
+			return fmt.Errorf("unknown preset %s", t.Preset)
+		}
+
+		applyPreset(t, preset)
+	}
+
+	err := validateTransformation(t)
+	if err == nil {
+		err = checkTargetLimits(settings, t)
+	}
+	if err != nil {
+		if t.Preset != "" {
+			return fmt.Errorf("preset %s: %v", t.Preset, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Fills in the options the transformation leaves unset with those of the
+// preset. As the preset's options end up in the transformation, they are
+// part of the image ID, so editing a preset yields new images.
+func applyPreset(t *model.Transformation, preset settings.Preset) {
+	if t.Width == 0 && t.Height == 0 {
+		t.Width, t.Height = preset.Width, preset.Height
+	}
+
+	*t = inheritTransformation(*t, model.Transformation{
+		Format:   preset.Format,
+		Quality:  preset.Quality,
+		Fit:      preset.Fit,
+		Gravity:  preset.Gravity,
+		Metadata: preset.Metadata,
+	})
+}
+
+// Returns the transformations a resize request asks for; one per variant if
+// the request has variants, or the request's own transformation otherwise.
+func requestTransformations(request *model.ResizeRequest) []model.Transformation {
+	if len(request.Variants) == 0 {
+		return []model.Transformation{request.Transformation}
+	}
+
+	transformations := make([]model.Transformation, 0, len(request.Variants))
+	for _, variant := range request.Variants {
+		transformations = append(transformations, inheritTransformation(variant, request.Transformation))
+	}
+
+	return transformations
+}
+
+// Fills in the options, other than the dimensions, that the variant leaves
+// unset with those of the parent transformation.
+func inheritTransformation(variant model.Transformation, parent model.Transformation) model.Transformation {
+	if variant.Format == "" {
+		variant.Format = parent.Format
+	}
+	if variant.Quality == 0 {
+		variant.Quality = parent.Quality
+	}
+	if variant.Fit == "" {
+		variant.Fit = parent.Fit
+	}
+	if variant.Gravity == "" {
+		variant.Gravity = parent.Gravity
+	}
+	if variant.Metadata == "" {
+		variant.Metadata = parent.Metadata
+	}
+
+	return variant
+}
+
+func validateTransformation(t *model.Transformation) error {
+	t.Format = strings.ToLower(t.Format)
+	if t.Format == "jpg" {
+		t.Format = "jpeg"
+	}
+	if t.Format != "" {
+		if _, err := defaultFormats.LookupEncoder(t.Format); err != nil {
+			return err
+		}
+	}
+
+	if t.Quality < 0 || t.Quality > 100 {
+		return errors.New("quality must be between 1 and 100")
+	}
+
+	t.Fit = strings.ToLower(t.Fit)
+	if t.Fit != "" && !slices.Contains(fits, t.Fit) {
+		return fmt.Errorf("fit must be one of %s", strings.Join(fits, ", "))
+	}
+
+	t.Gravity = strings.ToLower(t.Gravity)
+	if t.Gravity != "" && !slices.Contains(gravities, t.Gravity) {
+		return fmt.Errorf("gravity must be one of %s", strings.Join(gravities, ", "))
+	}
+
+	t.Metadata = strings.ToLower(t.Metadata)
+	if t.Metadata != "" && !slices.Contains(metadataOptions, t.Metadata) {
+		return fmt.Errorf("metadata must be one of %s", strings.Join(metadataOptions, ", "))
+	}
+
+	return nil
+}
+
+func outputFormat(t model.Transformation) string {
+	if t.Format == "" {
+		return defaultOutputFormat
+	}
+
+	return t.Format
+}
+
+// JPEG has no alpha channel, so images padded for it get a white background
+// rather than a transparent one.
+func backgroundColor(t model.Transformation) color.Color {
+	if outputFormat(t) == "jpeg" {
+		return color.White
+	}
+
+	return color.Transparent
+}
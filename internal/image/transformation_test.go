@@ -0,0 +1,152 @@
+package image_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+func TestValidateResizeRequest(t *testing.T) {
+	request := &model.ResizeRequest{Transformation: model.Transformation{Format: "JPG", Quality: 90}}
+	if err := image.ValidateResizeRequest(buildSettings(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if request.Format != "jpeg" {
+		t.Errorf("expected format to be normalized, got: %s", request.Format)
+	}
+
+	request = &model.ResizeRequest{Transformation: model.Transformation{Format: "webp"}}
+	if err := image.ValidateResizeRequest(buildSettings(), request); !errors.Is(err, image.ErrUnsupportedFormat) {
+		t.Errorf("expected unsupported format error, got: %v", err)
+	}
+
+	request = &model.ResizeRequest{Transformation: model.Transformation{Quality: 101}}
+	if err := image.ValidateResizeRequest(buildSettings(), request); err == nil {
+		t.Error("expected out of range quality to be rejected")
+	}
+}
+
+func TestValidateResizeRequestFit(t *testing.T) {
+	request := &model.ResizeRequest{Transformation: model.Transformation{Fit: "Cover", Gravity: "NorthEast"}}
+	if err := image.ValidateResizeRequest(buildSettings(), request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if request.Fit != "cover" || request.Gravity != "northeast" {
+		t.Errorf("expected fit and gravity to be normalized, got: %s, %s", request.Fit, request.Gravity)
+	}
+
+	request = &model.ResizeRequest{Transformation: model.Transformation{Fit: "stretch"}}
+	if err := image.ValidateResizeRequest(buildSettings(), request); err == nil {
+		t.Error("expected unknown fit to be rejected")
+	}
+
+	request = &model.ResizeRequest{Transformation: model.Transformation{Gravity: "up"}}
+	if err := image.ValidateResizeRequest(buildSettings(), request); err == nil {
+		t.Error("expected unknown gravity to be rejected")
+	}
+}
+
+func TestValidateResizeRequestPreset(t *testing.T) {
+	s := buildSettings()
+	s.Service.Presets = settings.Presets{
+		"thumb": {Width: 150, Height: 150, Fit: "cover", Format: "png"},
+	}
+
+	request := &model.ResizeRequest{
+		Transformation: model.Transformation{Preset: "thumb", Format: "gif"},
+		Variants:       []model.Transformation{{Preset: "thumb"}, {Width: 50}},
+	}
+	if err := image.ValidateResizeRequest(s, request); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if request.Width != 150 || request.Height != 150 || request.Fit != "cover" || request.Format != "gif" {
+		t.Errorf("unexpected transformation resolved from preset: %v", request.Transformation)
+	}
+
+	if request.Variants[0].Width != 150 || request.Variants[0].Format != "png" {
+		t.Errorf("unexpected variant resolved from preset: %v", request.Variants[0])
+	}
+
+	request = &model.ResizeRequest{Transformation: model.Transformation{Preset: "hero"}}
+	if err := image.ValidateResizeRequest(s, request); err == nil {
+		t.Error("expected unknown preset to be rejected")
+	}
+}
+
+func TestValidateResizeRequestTargetLimits(t *testing.T) {
+	s := buildSettings()
+	s.Service.MaxTargetWidth = 1000
+	s.Service.MaxTargetHeight = 1000
+
+	request := &model.ResizeRequest{Transformation: model.Transformation{Width: 1000, Height: 1000}}
+	if err := image.ValidateResizeRequest(s, request); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	request = &model.ResizeRequest{Transformation: model.Transformation{Width: 1001}}
+	if err := image.ValidateResizeRequest(s, request); err == nil {
+		t.Error("expected width over the limit to be rejected")
+	}
+
+	request = &model.ResizeRequest{Variants: []model.Transformation{{Height: 1001}}}
+	if err := image.ValidateResizeRequest(s, request); err == nil {
+		t.Error("expected variant height over the limit to be rejected")
+	}
+}
+
+func TestValidateResizeRequestCallbackURL(t *testing.T) {
+	s := buildSettings()
+	request := &model.ResizeRequest{CallbackURL: "https://example.com/hook"}
+	if err := image.ValidateResizeRequest(s, request); err == nil {
+		t.Error("expected callback to be rejected without a webhook secret")
+	}
+
+	s.Service.WebhookSecret = "secret"
+	if err := image.ValidateResizeRequest(s, request); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	request = &model.ResizeRequest{CallbackURL: "file:///etc/passwd"}
+	if err := image.ValidateResizeRequest(s, request); err == nil {
+		t.Error("expected callback url with a forbidden scheme to be rejected")
+	}
+}
+
+func TestValidateResizeRequestPriority(t *testing.T) {
+	request := &model.ResizeRequest{Priority: "High"}
+	if err := image.ValidateResizeRequest(buildSettings(), request); err != nil || request.Priority != "high" {
+		t.Errorf("unexpected priority: %s, %v", request.Priority, err)
+	}
+
+	request = &model.ResizeRequest{Priority: "urgent"}
+	if err := image.ValidateResizeRequest(buildSettings(), request); err == nil {
+		t.Error("expected unknown priority to be rejected")
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	schedule := &model.Schedule{URL: "https://example.com/a.jpg", Variants: []model.Transformation{{Width: 10, Format: "JPG"}}}
+	if err := image.ValidateSchedule(buildSettings(), schedule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if schedule.Variants[0].Format != "jpeg" {
+		t.Errorf("expected format to be normalized, got: %s", schedule.Variants[0].Format)
+	}
+
+	for _, schedule := range []*model.Schedule{
+		{URL: "https://example.com/a.jpg"},
+		{URL: "ftp://example.com/a.jpg", Variants: []model.Transformation{{Width: 10}}},
+		{URL: "https://example.com/a.jpg", Variants: []model.Transformation{{Quality: 101}}},
+	} {
+		if err := image.ValidateSchedule(buildSettings(), schedule); err == nil {
+			t.Errorf("expected schedule to be rejected: %v", schedule)
+		}
+	}
+}
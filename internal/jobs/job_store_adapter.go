@@ -0,0 +1,17 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// Returned when updating a job that doesn't exist, or has expired.
+var ErrJobNotFound = errors.New("job not found")
+
+type JobStoreAdapter interface {
+	Create(ctx context.Context, job *model.Job) error
+	Get(ctx context.Context, batchID string) (*model.Job, bool)
+	Update(ctx context.Context, batchID string, update func(job *model.Job)) error
+}
@@ -0,0 +1,102 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// MemoryJobStore keeps job records in memory, each for the retention period
+// since it was last updated. A zero retention keeps them for good.
+type MemoryJobStore struct {
+	retention time.Duration
+	jobs      map[string]*memoryJob
+	mu        sync.Mutex
+}
+
+type memoryJob struct {
+	job       *model.Job
+	expiresAt time.Time
+}
+
+func NewMemoryJobStore(retention time.Duration) JobStoreAdapter {
+	return &MemoryJobStore{
+		retention: retention,
+		jobs:      make(map[string]*memoryJob),
+	}
+}
+
+func (store *MemoryJobStore) Create(_ context.Context, job *model.Job) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// Expired jobs are swept whenever a new one comes in, so that the store
+	// doesn't grow without bounds
+	now := time.Now()
+	for batchID, entry := range store.jobs {
+		if entry.expired(now) {
+			delete(store.jobs, batchID)
+		}
+	}
+
+	store.jobs[job.BatchID] = &memoryJob{job: job.Clone(), expiresAt: store.expiresAt(now)}
+
+	return nil
+}
+
+// Returns a copy of the job, which is safe to use while the job gets updated.
+func (store *MemoryJobStore) Get(_ context.Context, batchID string) (*model.Job, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.lookup(batchID)
+	if !ok {
+		return nil, false
+	}
+
+	return entry.job.Clone(), true
+}
+
+// Atomically updates the job and extends its retention.
+func (store *MemoryJobStore) Update(_ context.Context, batchID string, update func(job *model.Job)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.lookup(batchID)
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	update(entry.job)
+	entry.expiresAt = store.expiresAt(time.Now())
+
+	return nil
+}
+
+func (store *MemoryJobStore) lookup(batchID string) (*memoryJob, bool) {
+	entry, ok := store.jobs[batchID]
+	if !ok {
+		return nil, false
+	}
+
+	if entry.expired(time.Now()) {
+		delete(store.jobs, batchID)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (store *MemoryJobStore) expiresAt(now time.Time) time.Time {
+	if store.retention <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(store.retention)
+}
+
+func (entry *memoryJob) expired(now time.Time) bool {
+	return !entry.expiresAt.IsZero() && now.After(entry.expiresAt)
+}
@@ -0,0 +1,54 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/jobs"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+func TestMemoryJobStoreUpdate(t *testing.T) {
+	store := jobs.NewMemoryJobStore(time.Minute)
+	ctx := context.Background()
+
+	_ = store.Create(ctx, model.NewJob("batch", []model.JobImage{{URL: "https://example.com/a.jpg", ID: "a"}}, time.Now()))
+
+	err := store.Update(ctx, "batch", func(job *model.Job) {
+		job.Images[0].Start(time.Now())
+		job.UpdateState(time.Now())
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	job, ok := store.Get(ctx, "batch")
+	if !ok || job.State != model.JobStateRunning || job.Images[0].Attempts != 1 {
+		t.Errorf("unexpected job: %v", job)
+	}
+
+	// changes to the returned job don't affect the stored one
+	job.Images[0].State = model.JobStateFailed
+	if job, _ := store.Get(ctx, "batch"); job.Images[0].State != model.JobStateRunning {
+		t.Errorf("expected stored job to be unchanged, got: %v", job.Images[0].State)
+	}
+}
+
+func TestMemoryJobStoreRetention(t *testing.T) {
+	store := jobs.NewMemoryJobStore(10 * time.Millisecond)
+	ctx := context.Background()
+
+	_ = store.Create(ctx, model.NewJob("batch", nil, time.Now()))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := store.Get(ctx, "batch"); ok {
+		t.Error("expected job to expire")
+	}
+
+	err := store.Update(ctx, "batch", func(_ *model.Job) {})
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected job not found error, got: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+const (
+	jobKeyPrefix = "img-resize:job:"
+
+	// How long an update waits at first, and at most, before it's retried
+	// when the job changed under it. The wait doubles on each retry.
+	minUpdateBackoff = time.Millisecond
+	maxUpdateBackoff = 50 * time.Millisecond
+)
+
+// RedisJobStore keeps job records in Redis, so that they're shared by all
+// instances, each for the retention period since it was last updated. A
+// zero retention keeps them for good.
+type RedisJobStore struct {
+	client    *redis.Client
+	retention time.Duration
+}
+
+func NewRedisJobStore(client *redis.Client, retention time.Duration) JobStoreAdapter {
+	return &RedisJobStore{
+		client:    client,
+		retention: retention,
+	}
+}
+
+func (store *RedisJobStore) Create(ctx context.Context, job *model.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return store.client.Set(ctx, jobKeyPrefix+job.BatchID, data, store.retention).Err()
+}
+
+func (store *RedisJobStore) Get(ctx context.Context, batchID string) (*model.Job, bool) {
+	data, err := store.client.Get(ctx, jobKeyPrefix+batchID).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("error reading job %s: %v", batchID, err)
+		}
+		return nil, false
+	}
+
+	job := &model.Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		log.Printf("error decoding job %s: %v", batchID, err)
+		return nil, false
+	}
+
+	return job, true
+}
+
+// Atomically updates the job and extends its retention. The job is watched
+// while it's being updated, and the update is retried if the job changed in
+// the meantime, for instance by a worker of another instance, backing off a
+// random while so that contending workers don't keep clashing, until the
+// context is done.
+func (store *RedisJobStore) Update(ctx context.Context, batchID string, update func(job *model.Job)) error {
+	key := jobKeyPrefix + batchID
+
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ErrJobNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		job := &model.Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			return err
+		}
+		update(job)
+		if data, err = json.Marshal(job); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, store.retention)
+			return nil
+		})
+		return err
+	}
+
+	backoff := minUpdateBackoff
+	for {
+		err := store.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rand.N(backoff) + 1):
+		}
+		backoff = min(2*backoff, maxUpdateBackoff)
+	}
+}
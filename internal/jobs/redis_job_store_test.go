@@ -0,0 +1,127 @@
+package jobs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/okulik/img-resize/internal/jobs"
+	"github.com/okulik/img-resize/internal/model"
+)
+
+func TestRedisJobStoreGet(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectGet("img-resize:job:batch").SetVal(`{"batch_id":"batch","state":"queued","images":[{"url":"https://example.com/a.jpg","id":"a","state":"queued"}]}`)
+	mock.ExpectGet("img-resize:job:missing").RedisNil()
+
+	store := jobs.NewRedisJobStore(db, time.Minute)
+
+	job, ok := store.Get(context.Background(), "batch")
+	if !ok || job.State != model.JobStateQueued || len(job.Images) != 1 {
+		t.Errorf("unexpected job: %v", job)
+	}
+
+	if _, ok := store.Get(context.Background(), "missing"); ok {
+		t.Error("expected missing job not to be found")
+	}
+}
+
+func TestRedisJobStoreUpdate(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	now := time.Now().UTC()
+	job := model.NewJob("batch", []model.JobImage{{URL: "https://example.com/a.jpg", ID: "a"}}, now)
+	data, _ := json.Marshal(job)
+
+	job.Images[0].Start(now)
+	job.UpdateState(now)
+	updated, _ := json.Marshal(job)
+
+	mock.ExpectWatch("img-resize:job:batch")
+	mock.ExpectGet("img-resize:job:batch").SetVal(string(data))
+	mock.ExpectTxPipeline()
+	mock.ExpectSet("img-resize:job:batch", updated, time.Minute).SetVal("OK")
+	mock.ExpectTxPipelineExec()
+	mock.ExpectWatch("img-resize:job:missing")
+	mock.ExpectGet("img-resize:job:missing").RedisNil()
+
+	store := jobs.NewRedisJobStore(db, time.Minute)
+
+	err := store.Update(context.Background(), "batch", func(job *model.Job) {
+		job.Images[0].Start(now)
+		job.UpdateState(now)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = store.Update(context.Background(), "missing", func(_ *model.Job) {})
+	if !errors.Is(err, jobs.ErrJobNotFound) {
+		t.Errorf("expected job not found error, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisJobStoreUpdateRetriesConcurrentUpdates(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	now := time.Now().UTC()
+	job := model.NewJob("batch", []model.JobImage{{URL: "https://example.com/a.jpg", ID: "a"}, {URL: "https://example.com/b.jpg", ID: "b"}}, now)
+	data, _ := json.Marshal(job)
+
+	job.Images[1].Start(now)
+	updated, _ := json.Marshal(job)
+
+	// other workers keep updating the job while it's being updated, many
+	// more times than a fixed number of retries would allow
+	for i := 0; i < 20; i++ {
+		mock.ExpectWatch("img-resize:job:batch")
+		mock.ExpectGet("img-resize:job:batch").SetVal(string(data))
+		mock.ExpectTxPipeline()
+		mock.ExpectSet("img-resize:job:batch", updated, time.Minute).SetVal("OK")
+		mock.ExpectTxPipelineExec().SetErr(redis.TxFailedErr)
+	}
+	mock.ExpectWatch("img-resize:job:batch")
+	mock.ExpectGet("img-resize:job:batch").SetVal(string(data))
+	mock.ExpectTxPipeline()
+	mock.ExpectSet("img-resize:job:batch", updated, time.Minute).SetVal("OK")
+	mock.ExpectTxPipelineExec()
+
+	store := jobs.NewRedisJobStore(db, time.Minute)
+	err := store.Update(context.Background(), "batch", func(job *model.Job) {
+		job.Images[1].Start(now)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// the update gives up once its context is done
+	mock.ExpectWatch("img-resize:job:batch")
+	mock.ExpectGet("img-resize:job:batch").SetVal(string(data))
+	mock.ExpectTxPipeline()
+	mock.ExpectSet("img-resize:job:batch", updated, time.Minute).SetVal("OK")
+	mock.ExpectTxPipelineExec().SetErr(redis.TxFailedErr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = store.Update(ctx, "batch", func(job *model.Job) {
+		job.Images[1].Start(now)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled error, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,198 @@
+package model
+
+import "time"
+
+// States of async resize jobs, and of the images in them.
+const (
+	JobStateQueued    = "queued"
+	JobStateRunning   = "running"
+	JobStateSucceeded = "succeeded"
+	JobStateFailed    = "failed"
+	JobStateCancelled = "cancelled"
+)
+
+// States of job callback deliveries.
+const (
+	CallbackStatePending   = "pending"
+	CallbackStateDelivered = "delivered"
+	CallbackStateFailed    = "failed"
+)
+
+// Job tracks the images of a single async resize call, identified by its
+// batch ID. The state of the job is derived from the states of its images.
+type Job struct {
+	BatchID    string       `json:"batch_id"`
+	State      string       `json:"state"`
+	Priority   string       `json:"priority,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Images     []JobImage   `json:"images"`
+	Callback   *JobCallback `json:"callback,omitempty"`
+}
+
+// JobImage tracks a single resized image of a job, that is a single variant
+// of a source image. A Followed image was already being resized when the job
+// was created, so it's done once that resize is.
+type JobImage struct {
+	URL          string     `json:"url"`
+	ID           string     `json:"id"`
+	State        string     `json:"state"`
+	Cached       bool       `json:"cached"`
+	Error        string     `json:"error,omitempty"`
+	ErrorCode    string     `json:"error_code,omitempty"`
+	OriginStatus int        `json:"origin_status,omitempty"`
+	Attempts     int        `json:"attempts"`
+	QueuedAt     time.Time  `json:"queued_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	RetryAt      *time.Time `json:"retry_at,omitempty"`
+	Followed     bool       `json:"followed,omitempty"`
+}
+
+// JobCallback tracks the delivery of a job's results to its callback URL.
+type JobCallback struct {
+	URL      string            `json:"url"`
+	State    string            `json:"state"`
+	Attempts []CallbackAttempt `json:"attempts"`
+}
+
+// CallbackAttempt records a single attempt to deliver a job's results.
+type CallbackAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// JobCallbackPayload is posted to the callback URL of a job once all of its
+// images are done.
+type JobCallbackPayload struct {
+	BatchID    string     `json:"batch_id"`
+	State      string     `json:"state"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Images     []JobImage `json:"images"`
+}
+
+// Creates a new queued job.
+func NewJob(batchID string, images []JobImage, now time.Time) *Job {
+	for i := range images {
+		images[i].State = JobStateQueued
+		images[i].QueuedAt = now
+	}
+
+	job := &Job{BatchID: batchID, CreatedAt: now, Images: images}
+	job.UpdateState(now)
+
+	return job
+}
+
+// Marks the image as running, counting another attempt.
+func (img *JobImage) Start(now time.Time) {
+	img.State = JobStateRunning
+	img.StartedAt = &now
+	img.Attempts++
+}
+
+// Marks the running image as queued again, after its run was interrupted,
+// without counting the attempt.
+func (img *JobImage) Interrupt() {
+	img.State = JobStateQueued
+	img.Attempts--
+}
+
+// Marks the image as done, with the outcome taken from the resize response.
+func (img *JobImage) Finish(resp ResizeResponse, now time.Time) {
+	img.State = JobStateSucceeded
+	if resp.Result == "failure" {
+		img.State = JobStateFailed
+	}
+	img.Cached = resp.Cached
+	img.Error, img.ErrorCode, img.OriginStatus = resp.Error, resp.ErrorCode, resp.OriginStatus
+	img.FinishedAt = &now
+	img.RetryAt = nil
+}
+
+// Marks the failed image as queued for another attempt at retryAt, keeping
+// the error of the failed attempt.
+func (img *JobImage) Retry(resp ResizeResponse, retryAt time.Time) {
+	img.State = JobStateQueued
+	img.Error, img.ErrorCode, img.OriginStatus = resp.Error, resp.ErrorCode, resp.OriginStatus
+	img.RetryAt = &retryAt
+}
+
+// Marks the image as queued anew, as if it was never attempted.
+func (img *JobImage) Requeue(now time.Time) {
+	*img = JobImage{URL: img.URL, ID: img.ID, State: JobStateQueued, QueuedAt: now}
+}
+
+// Marks the image as cancelled, so that it's not resized, or no longer.
+func (img *JobImage) Cancel(now time.Time) {
+	img.State = JobStateCancelled
+	img.FinishedAt = &now
+	img.RetryAt = nil
+}
+
+// Returns true if the image is done, successfully or not, or was cancelled.
+func (img *JobImage) Done() bool {
+	return img.State == JobStateSucceeded || img.State == JobStateFailed || img.State == JobStateCancelled
+}
+
+// Derives the state of the job from the states of its images. The job is
+// queued until any of its images starts, and once all of them are done, it
+// has failed if any of them has failed, or else was cancelled if any of them
+// was. A job whose images are requeued is no longer finished.
+func (j *Job) UpdateState(now time.Time) {
+	j.UpdatedAt = now
+
+	queued, done, failed, cancelled := 0, 0, 0, 0
+	for _, img := range j.Images {
+		switch {
+		case img.State == JobStateQueued:
+			queued++
+		case img.State == JobStateFailed:
+			done++
+			failed++
+		case img.State == JobStateCancelled:
+			done++
+			cancelled++
+		case img.Done():
+			done++
+		}
+	}
+
+	switch {
+	case done == len(j.Images):
+		j.State = JobStateSucceeded
+		if failed > 0 {
+			j.State = JobStateFailed
+		} else if cancelled > 0 {
+			j.State = JobStateCancelled
+		}
+		if j.FinishedAt == nil {
+			j.FinishedAt = &now
+		}
+	case queued == len(j.Images):
+		j.State = JobStateQueued
+		j.FinishedAt = nil
+	default:
+		j.State = JobStateRunning
+		j.FinishedAt = nil
+	}
+}
+
+// Returns a deep copy of the job.
+func (j *Job) Clone() *Job {
+	clone := *j
+	clone.Images = append([]JobImage(nil), j.Images...)
+	if j.Callback != nil {
+		callback := *j.Callback
+		callback.Attempts = append([]CallbackAttempt(nil), j.Callback.Attempts...)
+		clone.Callback = &callback
+	}
+	return &clone
+}
+
+// Returns the payload posted to the job's callback URL.
+func (j *Job) CallbackPayload() JobCallbackPayload {
+	return JobCallbackPayload{BatchID: j.BatchID, State: j.State, FinishedAt: j.FinishedAt, Images: j.Images}
+}
@@ -0,0 +1,78 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+func TestJobUpdateState(t *testing.T) {
+	now := time.Now()
+	job := model.NewJob("batch", []model.JobImage{{ID: "a"}, {ID: "b"}}, now)
+	if job.State != model.JobStateQueued {
+		t.Fatalf("expected new job to be queued, got: %s", job.State)
+	}
+
+	job.Images[0].Start(now)
+	job.Images[0].Finish(model.ResizeResponse{Result: "success"}, now)
+	job.UpdateState(now)
+	if job.State != model.JobStateRunning || job.FinishedAt != nil {
+		t.Errorf("expected partly done job to be running, got: %s", job.State)
+	}
+
+	job.Images[1].Start(now)
+	job.Images[1].Finish(model.ResizeResponse{Result: "failure", Error: "failed", ErrorCode: model.ErrorCodeTimeout}, now)
+	job.UpdateState(now)
+	if job.State != model.JobStateFailed || job.FinishedAt == nil {
+		t.Errorf("expected job to have failed, got: %s", job.State)
+	}
+
+	if job.Images[1].ErrorCode != model.ErrorCodeTimeout || job.Images[1].Attempts != 1 {
+		t.Errorf("unexpected image: %v", job.Images[1])
+	}
+}
+
+func TestJobRetryAndRequeue(t *testing.T) {
+	now := time.Now()
+	job := model.NewJob("batch", []model.JobImage{{ID: "a"}}, now)
+
+	job.Images[0].Start(now)
+	job.Images[0].Retry(model.ResizeResponse{Result: "failure", Error: "failed", ErrorCode: model.ErrorCodeOriginStatus}, now.Add(time.Second))
+	job.UpdateState(now)
+	if job.State != model.JobStateQueued || job.Images[0].RetryAt == nil || job.Images[0].ErrorCode != model.ErrorCodeOriginStatus {
+		t.Errorf("expected image to be queued for a retry, got: %v", job.Images[0])
+	}
+
+	job.Images[0].Start(now)
+	job.Images[0].Finish(model.ResizeResponse{Result: "failure", Error: "failed", ErrorCode: model.ErrorCodeOriginStatus}, now)
+	job.UpdateState(now)
+	if job.State != model.JobStateFailed || job.Images[0].Attempts != 2 || job.Images[0].RetryAt != nil {
+		t.Errorf("expected job to have failed, got: %v", job.Images[0])
+	}
+
+	job.Images[0].Requeue(now)
+	job.UpdateState(now)
+	if job.State != model.JobStateQueued || job.FinishedAt != nil || job.Images[0].Attempts != 0 || job.Images[0].Error != "" {
+		t.Errorf("expected job to be queued anew, got: %v", job.Images[0])
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	now := time.Now()
+	job := model.NewJob("batch", []model.JobImage{{ID: "a"}, {ID: "b"}}, now)
+
+	job.Images[0].Start(now)
+	job.Images[0].Finish(model.ResizeResponse{Result: "success"}, now)
+	job.Images[1].Cancel(now)
+	job.UpdateState(now)
+	if job.State != model.JobStateCancelled || job.FinishedAt == nil || !job.Images[1].Done() {
+		t.Errorf("expected job to be cancelled, got: %s", job.State)
+	}
+
+	job.Images[0].Finish(model.ResizeResponse{Result: "failure", Error: "failed", ErrorCode: model.ErrorCodeTimeout}, now)
+	job.UpdateState(now)
+	if job.State != model.JobStateFailed {
+		t.Errorf("expected failed image to prevail over cancelled one, got: %s", job.State)
+	}
+}
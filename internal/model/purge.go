@@ -0,0 +1,23 @@
+package model
+
+import (
+	"encoding/json"
+)
+
+// PurgeRequest asks for every cached image derived from the source image at
+// URL to be removed.
+type PurgeRequest struct {
+	URL string `json:"url"`
+}
+
+// PurgeResponse tells how many cached images were removed.
+type PurgeResponse struct {
+	URL    string `json:"url"`
+	Purged int    `json:"purged"`
+}
+
+func NewPurgeRequestFromJSON(data []byte) (*PurgeRequest, error) {
+	var req PurgeRequest
+	err := json.Unmarshal(data, &req)
+	return &req, err
+}
@@ -4,10 +4,31 @@ import (
 	"encoding/json"
 )
 
+// Transformation describes how a resized image is produced from its source.
+// Zero values of the options other than the dimensions select the resizer's
+// defaults. A named Preset supplies the options left unset.
+type Transformation struct {
+	Preset   string `json:"preset,omitempty"`
+	Width    uint   `json:"width"`
+	Height   uint   `json:"height"`
+	Format   string `json:"format,omitempty"`
+	Quality  int    `json:"quality,omitempty"`
+	Fit      string `json:"fit,omitempty"`
+	Gravity  string `json:"gravity,omitempty"`
+	Metadata string `json:"metadata,omitempty"`
+}
+
+// ResizeRequest asks for the images at URLs to be resized. If Variants are
+// given, each image is resized once per variant; options a variant leaves
+// unset, other than its dimensions, are taken from the request itself. For
+// async requests, the results are posted to CallbackURL once all images are
+// done, and Priority (high, normal or bulk) decides how soon they're resized.
 type ResizeRequest struct {
-	URLs   []string `json:"urls"`
-	Width  uint     `json:"width"`
-	Height uint     `json:"height"`
+	URLs []string `json:"urls"`
+	Transformation
+	Variants    []Transformation `json:"variants,omitempty"`
+	CallbackURL string           `json:"callback_url,omitempty"`
+	Priority    string           `json:"priority,omitempty"`
 }
 
 func NewResizeRequestFromJSON(data []byte) (*ResizeRequest, error) {
@@ -43,6 +43,53 @@ func TestNewResizeRequestFromJSON(t *testing.T) {
 	}
 }
 
+func TestNewResizeRequestWithFormatFromJSON(t *testing.T) {
+	json := `{
+		"urls": ["https://i.imgur.com/RzW6QSI.jpeg"],
+		"width": 200,
+		"format": "png",
+		"quality": 80
+	}`
+	resizeReq, err := model.NewResizeRequestFromJSON([]byte(json))
+
+	if err != nil {
+		t.Errorf("Failed to parse JSON: %v", err)
+	}
+
+	if resizeReq.Format != "png" {
+		t.Errorf("Unexpected format value: %v", resizeReq.Format)
+	}
+
+	if resizeReq.Quality != 80 {
+		t.Errorf("Unexpected quality value: %v", resizeReq.Quality)
+	}
+}
+
+func TestNewResizeRequestWithVariantsFromJSON(t *testing.T) {
+	json := `{
+		"urls": ["https://i.imgur.com/RzW6QSI.jpeg"],
+		"format": "png",
+		"variants": [{"width": 320}, {"width": 640, "format": "gif"}]
+	}`
+	resizeReq, err := model.NewResizeRequestFromJSON([]byte(json))
+
+	if err != nil {
+		t.Errorf("Failed to parse JSON: %v", err)
+	}
+
+	if len(resizeReq.Variants) != 2 {
+		t.Fatalf("Unexpected number of variants: %v", len(resizeReq.Variants))
+	}
+
+	if resizeReq.Variants[0].Width != 320 || resizeReq.Variants[0].Format != "" {
+		t.Errorf("Unexpected variant value: %v", resizeReq.Variants[0])
+	}
+
+	if resizeReq.Variants[1].Width != 640 || resizeReq.Variants[1].Format != "gif" {
+		t.Errorf("Unexpected variant value: %v", resizeReq.Variants[1])
+	}
+}
+
 func TestNewResizeRequestFromInvalidJSON(t *testing.T) {
 	json := `{
 		"urls": "https://i.imgur.com/RzW6QSI.jpeg",
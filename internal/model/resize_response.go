@@ -1,7 +1,34 @@
 package model
 
+// Error codes of failed resize responses.
+const (
+	ErrorCodeFetchFailed  = "fetch_failed"
+	ErrorCodeOriginStatus = "origin_status"
+	ErrorCodeDecodeFailed = "decode_failed"
+	ErrorCodeTooLarge     = "too_large"
+	ErrorCodeTimeout      = "timeout"
+	ErrorCodeQueueFull    = "queue_full"
+	ErrorCodeResizeFailed = "resize_failed"
+
+	ErrorCodeQueueUnavailable = "queue_unavailable"
+	ErrorCodeCancelled        = "cancelled"
+)
+
+// ResizeResponse reports the outcome of resizing a single source image, or
+// a single variant of it. Failed responses carry the error, its code and,
+// for ErrorCodeOriginStatus, the status code the origin responded with.
+// Responses to async calls carry the batch ID of the call's job, and those
+// that weren't enqueued because the queue is full or unavailable carry the
+// number of seconds to wait before trying again.
 type ResizeResponse struct {
-	Result string `json:"result"`
-	ID     string `json:"id,omitempty"`
-	Cached bool   `json:"cached"`
+	Result       string           `json:"result"`
+	URL          string           `json:"url,omitempty"`
+	ID           string           `json:"id,omitempty"`
+	Cached       bool             `json:"cached"`
+	Error        string           `json:"error,omitempty"`
+	ErrorCode    string           `json:"error_code,omitempty"`
+	OriginStatus int              `json:"origin_status,omitempty"`
+	Variants     []ResizeResponse `json:"variants,omitempty"`
+	BatchID      string           `json:"batch_id,omitempty"`
+	RetryAfter   int              `json:"retry_after,omitempty"`
 }
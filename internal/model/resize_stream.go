@@ -0,0 +1,24 @@
+package model
+
+// Types of streamed resize records.
+const (
+	StreamRecordResult  = "result"
+	StreamRecordSummary = "summary"
+)
+
+// ResizeStreamResult is a streamed record holding the response for a single
+// source image, along with the index of its URL in the request.
+type ResizeStreamResult struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	ResizeResponse
+}
+
+// ResizeStreamSummary is the last streamed record, sent once all the images
+// in the batch are done.
+type ResizeStreamSummary struct {
+	Type      string `json:"type"`
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+}
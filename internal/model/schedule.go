@@ -0,0 +1,45 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Schedule has the source image at URL refetched on its cron-like Cron
+// schedule, and resized into each of its Variants, replacing the cached
+// images, whenever the source changed. Source identifies the version of the
+// source image that was fetched last.
+type Schedule struct {
+	ID        string           `json:"id"`
+	URL       string           `json:"url"`
+	Cron      string           `json:"cron"`
+	Variants  []Transformation `json:"variants"`
+	CreatedAt time.Time        `json:"created_at"`
+	NextRunAt time.Time        `json:"next_run_at"`
+	LastRunAt *time.Time       `json:"last_run_at,omitempty"`
+	ChangedAt *time.Time       `json:"changed_at,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Source    SourceVersion    `json:"source"`
+}
+
+// SourceVersion identifies a version of a source image, by the validators
+// its origin sent along with it, for conditional requests, and by a digest
+// of its bytes, for origins that send none.
+type SourceVersion struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Digest       string `json:"digest,omitempty"`
+}
+
+func NewScheduleFromJSON(data []byte) (*Schedule, error) {
+	var schedule Schedule
+	err := json.Unmarshal(data, &schedule)
+	return &schedule, err
+}
+
+// Returns a deep copy of the schedule.
+func (s *Schedule) Clone() *Schedule {
+	clone := *s
+	clone.Variants = append([]Transformation(nil), s.Variants...)
+	return &clone
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"encoding/json"
+)
+
+// WorkerPool describes the pool of background workers running async resizes.
+type WorkerPool struct {
+	Workers int `json:"workers"`
+}
+
+func NewWorkerPoolFromJSON(data []byte) (*WorkerPool, error) {
+	var pool WorkerPool
+	err := json.Unmarshal(data, &pool)
+	return &pool, err
+}
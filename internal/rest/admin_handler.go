@@ -0,0 +1,136 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+	"github.com/okulik/img-resize/internal/web"
+)
+
+const (
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 1000
+)
+
+type AdminHandler struct {
+	settings *settings.Settings
+	resizer  image.ImageResizer
+}
+
+// Creates a new instance of AdminHandler object.
+func NewAdminHandler(settings *settings.Settings, resizer image.ImageResizer) *AdminHandler {
+	return &AdminHandler{
+		settings: settings,
+		resizer:  resizer,
+	}
+}
+
+// A web handler for listing async resize jobs that ran out of attempts, most
+// recent first. The list is paged with the "offset" and "limit" query
+// parameters.
+func (ah *AdminHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		web.WriteErrorResponse(w, errors.New("invalid offset"), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultDeadLetterLimit)
+	if err != nil || limit <= 0 || limit > maxDeadLetterLimit {
+		web.WriteErrorResponse(w, errors.Errorf("limit must be between 1 and %d", maxDeadLetterLimit), http.StatusBadRequest)
+		return
+	}
+
+	letters, err := ah.resizer.DeadLetters().List(r.Context(), offset, limit)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to list dead letters"), http.StatusInternalServerError)
+		return
+	}
+
+	web.WriteJSONResponse(w, letters, http.StatusOK)
+}
+
+// A web handler for retrieving a single dead letter by its ID.
+func (ah *AdminHandler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	letter, ok := ah.resizer.DeadLetters().Get(r.Context(), id)
+	if !ok {
+		web.WriteErrorResponse(w, image.ErrDeadLetterNotFound, http.StatusNotFound)
+		return
+	}
+
+	web.WriteJSONResponse(w, letter, http.StatusOK)
+}
+
+// A web handler for enqueuing the job of a dead letter once more. The dead
+// letter is removed once the job is back in the queue.
+func (ah *AdminHandler) RequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	err := ah.resizer.RequeueDeadLetter(r.Context(), id)
+	switch {
+	case errors.Is(err, image.ErrDeadLetterNotFound):
+		web.WriteErrorResponse(w, err, http.StatusNotFound)
+		return
+	case err != nil:
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to requeue dead letter"), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// A web handler for retrieving the size of the pool of async resize workers.
+func (ah *AdminHandler) GetWorkers(w http.ResponseWriter, r *http.Request) {
+	web.WriteJSONResponse(w, model.WorkerPool{Workers: ah.resizer.Workers()}, http.StatusOK)
+}
+
+// A web handler for growing or shrinking the pool of async resize workers.
+// Workers that are let go finish the jobs they're running, so no job is
+// dropped.
+func (ah *AdminHandler) SetWorkers(w http.ResponseWriter, r *http.Request) {
+	buffer, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to read request body"), http.StatusBadRequest)
+		return
+	}
+
+	pool, err := model.NewWorkerPoolFromJSON(buffer)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid worker pool body"), http.StatusBadRequest)
+		return
+	}
+
+	err = ah.resizer.SetWorkers(pool.Workers)
+	switch {
+	case errors.Is(err, image.ErrAsyncResizeDisabled):
+		web.WriteErrorResponse(w, err, http.StatusConflict)
+		return
+	case errors.Is(err, image.ErrResizerStopped):
+		web.WriteErrorResponse(w, err, http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		web.WriteErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	web.WriteJSONResponse(w, model.WorkerPool{Workers: ah.resizer.Workers()}, http.StatusOK)
+}
+
+// Returns the integer value of a query parameter, or def if it's not set.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,150 @@
+package rest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	chi "github.com/go-chi/chi/v5"
+
+	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/rest"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+func TestListDeadLetters(t *testing.T) {
+	router, _ := buildAdminRouter(3)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/admin/dead-letters?offset=1&limit=1", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", testRecorder.Code)
+	}
+
+	body := testRecorder.Body.String()
+	if !strings.HasPrefix(body, `[{"id":"letter1"`) || strings.Contains(body, "letter0") {
+		t.Errorf("unexpected dead letters: %s", body)
+	}
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/admin/dead-letters?limit=0", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestGetDeadLetter(t *testing.T) {
+	router, _ := buildAdminRouter(1)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/admin/dead-letters/letter0", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK || !strings.Contains(testRecorder.Body.String(), `"attempts":3`) {
+		t.Errorf("unexpected response: %v %s", testRecorder.Code, testRecorder.Body.String())
+	}
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/admin/dead-letters/missing", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	router, resizer := buildAdminRouter(1)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/admin/dead-letters/letter0/requeue", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusAccepted {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+
+	if _, ok := resizer.DeadLetters().Get(context.Background(), "letter0"); ok {
+		t.Error("expected dead letter to be removed")
+	}
+
+	testRecorder = httptest.NewRecorder()
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func buildAdminRouter(letters int) (chi.Router, image.ImageResizer) {
+	settings, _ := settings.Load()
+	cache, _ := cache.NewLRUImageCache(1)
+	resizer := NewMockResizer(settings, cache)
+	for i := range letters {
+		letter := &image.DeadLetter{ID: fmt.Sprintf("letter%d", i), Job: &image.ResizeJob{URL: "https://example.com/a.jpg"}, Attempts: 3}
+		_ = resizer.DeadLetters().Add(context.Background(), letter)
+	}
+
+	handler := rest.NewAdminHandler(settings, resizer)
+	router := chi.NewRouter()
+	router.Get("/v1/admin/dead-letters", handler.ListDeadLetters)
+	router.Get("/v1/admin/dead-letters/{id}", handler.GetDeadLetter)
+	router.Post("/v1/admin/dead-letters/{id}/requeue", handler.RequeueDeadLetter)
+	router.Get("/v1/admin/workers", handler.GetWorkers)
+	router.Put("/v1/admin/workers", handler.SetWorkers)
+
+	return router, resizer
+}
+
+func TestSetWorkers(t *testing.T) {
+	router, _ := buildAdminRouter(0)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/v1/admin/workers", strings.NewReader(`{"workers": 8}`))
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK || !strings.Contains(testRecorder.Body.String(), `{"workers":8}`) {
+		t.Errorf("unexpected response: %v %s", testRecorder.Code, testRecorder.Body.String())
+	}
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/admin/workers", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK || !strings.Contains(testRecorder.Body.String(), `{"workers":8}`) {
+		t.Errorf("unexpected response: %v %s", testRecorder.Code, testRecorder.Body.String())
+	}
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/v1/admin/workers", strings.NewReader(`{"workers": 0}`))
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestSetWorkersWithAsyncResizeDisabled(t *testing.T) {
+	settings, _ := settings.Load()
+	settings.Service.AsyncResize = false
+	cache, _ := cache.NewLRUImageCache(1)
+	handler := rest.NewAdminHandler(settings, NewMockResizer(settings, cache))
+	router := chi.NewRouter()
+	router.Put("/v1/admin/workers", handler.SetWorkers)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/v1/admin/workers", strings.NewReader(`{"workers": 8}`))
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusConflict {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
@@ -1,14 +1,17 @@
 package rest
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	chi "github.com/go-chi/chi/v5"
 	"github.com/pkg/errors"
 
 	"github.com/okulik/img-resize/internal/cache"
 	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/jobs"
 	"github.com/okulik/img-resize/internal/model"
 	"github.com/okulik/img-resize/internal/settings"
 	"github.com/okulik/img-resize/internal/web"
@@ -17,6 +20,9 @@ import (
 const (
 	maxRequestSize     = 8 * 1024
 	maxBatchImageCount = 100
+	maxVariantCount    = 10
+
+	jobsPath = "/v1/jobs/"
 )
 
 type ResizerHandler struct {
@@ -38,6 +44,8 @@ func NewResizerHandler(settings *settings.Settings, imageCache cache.ImageCacheA
 // are resized either synchronously (the image is resized before the handler returns
 // to the caller) or asynchronously (the resizing job is enqueued for processing by a
 // fleet of background workers). The resized images are stored to an in-memory cache.
+// Synchronous results are streamed, one image at a time, when the client accepts
+// newline delimited JSON or server-sent events.
 func (rh *ResizerHandler) ResizeImage(w http.ResponseWriter, r *http.Request) {
 	// Limit POST body size to up to maxRequestSize bytes
 	buffer, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
@@ -58,13 +66,41 @@ func (rh *ResizerHandler) ResizeImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Limit the number of variants each image is resized into
+	if len(resizeReq.Variants) > maxVariantCount {
+		web.WriteErrorResponse(w, errors.Errorf("number of variants is limited to %d", maxVariantCount), http.StatusBadRequest)
+		return
+	}
+
+	if err := image.ValidateResizeRequest(rh.settings, resizeReq); err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid resize request"), http.StatusBadRequest)
+		return
+	}
+
+	if resizeReq.CallbackURL != "" && !isAsyncResize(r) {
+		web.WriteErrorResponse(w, errors.New("callback_url is only supported for async resizing"), http.StatusBadRequest)
+		return
+	}
+
 	if isAsyncResize(r) {
 		if !rh.settings.Service.AsyncResize {
 			web.WriteErrorResponse(w, errors.New("async resize is disabled"), http.StatusFailedDependency)
 			return
 		}
 		resp := rh.resizer.ProcessAsync(resizeReq)
-		web.WriteJSONResponse(w, resp, http.StatusOK)
+		if len(resp) > 0 {
+			w.Header().Set("Location", jobsPath+resp[0].BatchID)
+		}
+		status, retryAfter := admissionStatus(resp)
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+		web.WriteJSONResponse(w, resp, status)
+		return
+	}
+
+	if contentType := streamContentType(r); contentType != "" {
+		rh.streamResize(w, r, resizeReq, contentType)
 		return
 	}
 
@@ -76,14 +112,44 @@ func (rh *ResizerHandler) ResizeImage(w http.ResponseWriter, r *http.Request) {
 	web.WriteJSONResponse(w, resp, http.StatusCreated)
 }
 
+// Resizes the batch synchronously, writing a record for each image as soon as
+// it's done, in whatever order the images finish, followed by a summary record.
+func (rh *ResizerHandler) streamResize(w http.ResponseWriter, r *http.Request, resizeReq *model.ResizeRequest, contentType string) {
+	sw := newStreamWriter(w, contentType, rh.settings.Http.ServerWriteTimeout)
+
+	summary := model.ResizeStreamSummary{Type: model.StreamRecordSummary, Total: len(resizeReq.URLs)}
+	var writeErr error
+	err := rh.resizer.ProcessStream(resizeReq, r.Context(), func(i int, resp model.ResizeResponse) {
+		if resp.Result == "success" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+
+		// Once the client is gone, there's no point in writing any more records
+		if writeErr == nil {
+			writeErr = sw.Write(model.StreamRecordResult, model.ResizeStreamResult{Type: model.StreamRecordResult, Index: i, ResizeResponse: resp})
+		}
+	})
+	if err != nil || writeErr != nil {
+		return
+	}
+
+	_ = sw.Write(model.StreamRecordSummary, summary)
+}
+
 // A web handler for retrieving resized images from the in-memory cache.
 func (rh *ResizerHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	imageID := chi.URLParam(r, "imageID")
 
 	// If image is being resized, perform a blocking call (with a timeout)
-	if !rh.resizer.ResizingProgress().WaitForResizingDone(imageID) {
+	switch rh.resizer.ResizingProgress().WaitForResizingDone(r.Context(), imageID) {
+	case image.ResizingTimedOut:
 		web.WriteErrorResponse(w, errors.New("image resize timeout"), http.StatusNotFound)
 		return
+	case image.ResizingCancelled:
+		web.WriteErrorResponse(w, errors.New("image resize cancelled"), http.StatusNotFound)
+		return
 	}
 
 	// Check if the image was cached
@@ -96,14 +162,186 @@ func (rh *ResizerHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	web.WriteErrorResponse(w, errors.New("image not cached"), http.StatusNotFound)
 }
 
+// A web handler for removing a cached image before it expires, for instance
+// because it's outdated.
+func (rh *ResizerHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	imageID := chi.URLParam(r, "imageID")
+
+	deleted, err := rh.imageCache.Delete(r.Context(), imageID)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to delete image"), http.StatusServiceUnavailable)
+		return
+	}
+	if !deleted {
+		web.WriteErrorResponse(w, errors.New("image not cached"), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// A web handler for removing every cached image derived from a source image,
+// whatever its transformation.
+func (rh *ResizerHandler) PurgeSource(w http.ResponseWriter, r *http.Request) {
+	buffer, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to read request body"), http.StatusBadRequest)
+		return
+	}
+
+	purgeReq, err := model.NewPurgeRequestFromJSON(buffer)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid purge request body"), http.StatusBadRequest)
+		return
+	}
+	if purgeReq.URL == "" {
+		web.WriteErrorResponse(w, errors.New("url is required"), http.StatusBadRequest)
+		return
+	}
+
+	purged, err := rh.resizer.PurgeSource(r.Context(), purgeReq.URL)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to purge images"), http.StatusInternalServerError)
+		return
+	}
+
+	web.WriteJSONResponse(w, model.PurgeResponse{URL: purgeReq.URL, Purged: purged}, http.StatusOK)
+}
+
+// A web handler for retrieving the status of an async resize call, and of
+// each of the images in it, by the call's batch ID.
+func (rh *ResizerHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	batchID := chi.URLParam(r, "batchID")
+
+	job, ok := rh.resizer.Jobs().Get(r.Context(), batchID)
+	if !ok {
+		web.WriteErrorResponse(w, errors.New("job not found"), http.StatusNotFound)
+		return
+	}
+
+	web.WriteJSONResponse(w, job, http.StatusOK)
+}
+
+// A web handler for cancelling an async resize call, by its batch ID. All of
+// the call's images that aren't done yet are cancelled, whether they're
+// queued or being resized. Responds with the cancelled job.
+func (rh *ResizerHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	batchID := chi.URLParam(r, "batchID")
+
+	job, err := rh.resizer.CancelJob(r.Context(), batchID)
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		web.WriteErrorResponse(w, err, http.StatusNotFound)
+		return
+	case errors.Is(err, image.ErrJobFinished):
+		web.WriteErrorResponse(w, err, http.StatusConflict)
+		return
+	case err != nil:
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to cancel job"), http.StatusInternalServerError)
+		return
+	}
+
+	web.WriteJSONResponse(w, job, http.StatusOK)
+}
+
+// A web handler for resizing images on the fly, with the source URL and the
+// transformation options encoded in the request path, so that resized images
+// can be linked to directly. Instead of basic auth, the path is authorized by
+// its HMAC signature.
+func (rh *ResizerHandler) TransformImage(w http.ResponseWriter, r *http.Request) {
+	signature := chi.URLParam(r, "signature")
+	path := "/" + chi.URLParam(r, "*")
+
+	if !verifyTransformPath(rh.settings.Auth.URLSigningKey, path, signature) {
+		web.WriteErrorResponse(w, errors.New("invalid signature"), http.StatusForbidden)
+		return
+	}
+
+	url, transformation, err := parseTransformPath(path)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid transformation path"), http.StatusBadRequest)
+		return
+	}
+
+	resizeReq := &model.ResizeRequest{URLs: []string{url}, Transformation: transformation}
+	if err := image.ValidateResizeRequest(rh.settings, resizeReq); err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid transformation"), http.StatusBadRequest)
+		return
+	}
+
+	data, err := rh.resizer.Transform(r.Context(), url, resizeReq.Transformation)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to resize image"), transformStatus(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(rh.settings.Service.ImageCacheTTL.Seconds())))
+	writeImageResponse(w, data)
+}
+
+// Picks the status of the response to a failed image transformation, from
+// the classification of the error. Only origin failures are answered with
+// 502, apart from a 404 from the origin, which is passed through.
+func transformStatus(err error) int {
+	resp := image.FailureResponse(err)
+	switch resp.ErrorCode {
+	case model.ErrorCodeOriginStatus:
+		if resp.OriginStatus == http.StatusNotFound {
+			return http.StatusNotFound
+		}
+		return http.StatusBadGateway
+	case model.ErrorCodeFetchFailed:
+		return http.StatusBadGateway
+	case model.ErrorCodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case model.ErrorCodeDecodeFailed:
+		return http.StatusUnprocessableEntity
+	case model.ErrorCodeTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Picks the status of the response to an async call, from the number of
+// source images that weren't enqueued because the queue is full or
+// unavailable: 200 if there's none, 207 if only some of them weren't, and
+// 429, or 503 when the queue is unavailable, if none was. Also returns the
+// longest time, in seconds, the client is asked to wait before trying again.
+func admissionStatus(responses []model.ResizeResponse) (int, int) {
+	rejected, unavailable, retryAfter := 0, false, 0
+	for _, resp := range responses {
+		if resp.RetryAfter == 0 {
+			continue
+		}
+
+		rejected++
+		retryAfter = max(retryAfter, resp.RetryAfter)
+		for _, variant := range append([]model.ResizeResponse{resp}, resp.Variants...) {
+			unavailable = unavailable || variant.ErrorCode == model.ErrorCodeQueueUnavailable
+		}
+	}
+
+	switch {
+	case rejected == 0:
+		return http.StatusOK, 0
+	case rejected < len(responses):
+		return http.StatusMultiStatus, retryAfter
+	case unavailable:
+		return http.StatusServiceUnavailable, retryAfter
+	default:
+		return http.StatusTooManyRequests, retryAfter
+	}
+}
+
 func isAsyncResize(r *http.Request) bool {
 	async := r.URL.Query().Get("async")
 	return async == "true" || async == "1"
 }
 
 func writeImageResponse(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", image.DetectContentType(data))
 	w.WriteHeader(http.StatusOK)
-	w.Header().Add("content-type", "image/jpeg")
 	_, err := w.Write(data)
 	if err != nil {
 		web.WriteErrorResponse(w, errors.New("unable to write a response"), http.StatusInternalServerError)
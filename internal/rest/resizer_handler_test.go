@@ -2,16 +2,21 @@ package rest_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	chi "github.com/go-chi/chi/v5"
 
 	"github.com/okulik/img-resize/internal/cache"
+	"github.com/okulik/img-resize/internal/fetch"
 	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/jobs"
 	"github.com/okulik/img-resize/internal/model"
 	"github.com/okulik/img-resize/internal/rest"
 	"github.com/okulik/img-resize/internal/settings"
@@ -49,6 +54,68 @@ func TestResizeImage(t *testing.T) {
 	}
 }
 
+func TestResizeImageStreamNDJSON(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/v1/resize", strings.NewReader(json))
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	testRecorder := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Post("/v1/resize", buildResizerHandler().ResizeImage)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", testRecorder.Code)
+	}
+
+	if !strings.HasPrefix(testRecorder.Header().Get("Content-Type"), "application/x-ndjson") {
+		t.Errorf("unexpected content type: %s", testRecorder.Header().Get("Content-Type"))
+	}
+
+	lines := strings.Split(strings.TrimSpace(testRecorder.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 2 results and a summary, got: %v", lines)
+	}
+
+	if !strings.Contains(lines[0], `"type":"result","index":0`) || !strings.Contains(lines[0], "abc123") {
+		t.Errorf("unexpected first record: %s", lines[0])
+	}
+
+	if !strings.Contains(lines[1], `"index":1`) || !strings.Contains(lines[1], `"error_code":"origin_status"`) {
+		t.Errorf("unexpected second record: %s", lines[1])
+	}
+
+	if lines[2] != `{"type":"summary","total":2,"succeeded":1,"failed":1}` {
+		t.Errorf("unexpected summary record: %s", lines[2])
+	}
+}
+
+func TestResizeImageStreamSSE(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/v1/resize", strings.NewReader(json))
+	req.Header.Set("Accept", "text/event-stream")
+
+	testRecorder := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Post("/v1/resize", buildResizerHandler().ResizeImage)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", testRecorder.Code)
+	}
+
+	if !testRecorder.Flushed {
+		t.Error("expected records to be flushed")
+	}
+
+	body := testRecorder.Body.String()
+	if strings.Count(body, "event: result\ndata: ") != 2 {
+		t.Errorf("expected 2 result events, got: %s", body)
+	}
+
+	if !strings.HasSuffix(body, "event: summary\ndata: {\"type\":\"summary\",\"total\":2,\"succeeded\":1,\"failed\":1}\n\n") {
+		t.Errorf("unexpected summary event: %s", body)
+	}
+}
+
 func TestResizeImageAsync(t *testing.T) {
 	reader := io.NopCloser(strings.NewReader(json))
 	req, err := http.NewRequest("POST", "/v1/resize?async=true", reader)
@@ -70,11 +137,346 @@ func TestResizeImageAsync(t *testing.T) {
 	if !strings.Contains(testRecorder.Body.String(), "def456") {
 		t.Fatalf("unexpected image id")
 	}
+
+	if testRecorder.Header().Get("Retry-After") != "" {
+		t.Errorf("unexpected retry after: %s", testRecorder.Header().Get("Retry-After"))
+	}
+}
+
+func TestResizeImageAsyncBackpressure(t *testing.T) {
+	tests := []struct {
+		urls       string
+		status     int
+		retryAfter string
+	}{
+		{`"https://example.com/a.jpg", "https://example.com/queue-full.jpg"`, http.StatusMultiStatus, "7"},
+		{`"https://example.com/queue-full.jpg"`, http.StatusTooManyRequests, "7"},
+		{`"https://example.com/queue-full.jpg", "https://example.com/queue-down.jpg"`, http.StatusServiceUnavailable, "30"},
+	}
+
+	router := chi.NewRouter()
+	router.Post("/v1/resize", buildResizerHandler().ResizeImage)
+
+	for _, test := range tests {
+		body := `{"urls": [` + test.urls + `], "width": 200}`
+		req, _ := http.NewRequest("POST", "/v1/resize?async=true", strings.NewReader(body))
+		testRecorder := httptest.NewRecorder()
+		router.ServeHTTP(testRecorder, req)
+
+		if testRecorder.Code != test.status {
+			t.Errorf("%s: unexpected status code: %v", test.urls, testRecorder.Code)
+		}
+
+		if testRecorder.Header().Get("Retry-After") != test.retryAfter {
+			t.Errorf("%s: unexpected retry after: %s", test.urls, testRecorder.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestResizeImageWithUnsupportedFormat(t *testing.T) {
+	body := `{"urls": ["https://i.imgur.com/RzW6QSI.jpeg"], "width": 200, "format": "webp"}`
+	req, err := http.NewRequest("POST", "/v1/resize", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	handler := buildResizerHandler()
+
+	testRecorder := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Post("/v1/resize", handler.ResizeImage)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestGetImageContentType(t *testing.T) {
+	cache, _ := cache.NewLRUImageCache(1)
+	cache.Add(context.Background(), "abc123", []byte("\x89PNG\r\n\x1a\nrest-of-png"))
+	handler := buildResizerHandlerWithCache(cache)
+
+	req, err := http.NewRequest("GET", "/v1/image/abc123", nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	testRecorder := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/v1/image/{imageID}", handler.GetImage)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", testRecorder.Code)
+	}
+
+	if contentType := testRecorder.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Fatalf("unexpected content type: %v", contentType)
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	cache, _ := cache.NewLRUImageCache(1)
+	cache.Add(context.Background(), "abc123", []byte("\x89PNG\r\n\x1a\nrest-of-png"))
+	handler := buildResizerHandlerWithCache(cache)
+
+	router := chi.NewRouter()
+	router.Delete("/v1/image/{imageID}", handler.DeleteImage)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/v1/image/abc123", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status code: %v", testRecorder.Code)
+	}
+	if cache.Contains(context.Background(), "abc123") {
+		t.Error("expected image to be deleted")
+	}
+
+	testRecorder = httptest.NewRecorder()
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestDeleteImageFailure(t *testing.T) {
+	handler := buildResizerHandlerWithCache(&failingImageCache{})
+
+	router := chi.NewRouter()
+	router.Delete("/v1/image/{imageID}", handler.DeleteImage)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/v1/image/abc123", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+// An image cache whose deletes fail, as a Redis cache does when Redis is
+// down.
+type failingImageCache struct {
+	cache.ImageCacheAdapter
+}
+
+func (c *failingImageCache) Delete(_ context.Context, _ string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestPurgeSource(t *testing.T) {
+	cache, _ := cache.NewLRUImageCache(1)
+	cache.Add(context.Background(), "abc123", []byte("\x89PNG\r\n\x1a\nrest-of-png"))
+	handler := buildResizerHandlerWithCache(cache)
+
+	router := chi.NewRouter()
+	router.Post("/v1/purge", handler.PurgeSource)
+
+	testRecorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/purge", strings.NewReader(`{"url": "https://i.imgur.com/RzW6QSI.jpeg"}`))
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK || testRecorder.Body.String() != `{"url":"https://i.imgur.com/RzW6QSI.jpeg","purged":1}`+"\n" {
+		t.Errorf("unexpected response: %v %q", testRecorder.Code, testRecorder.Body.String())
+	}
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/purge", strings.NewReader(`{}`))
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestTransformImage(t *testing.T) {
+	handler := buildResizerHandler()
+	path := rest.BuildTransformPath("https://i.imgur.com/RzW6QSI.jpeg", model.Transformation{Width: 200, Fit: "cover", Format: "png"})
+
+	tests := []struct {
+		signature string
+		status    int
+	}{
+		{rest.SignTransformPath("secret", path), http.StatusOK},
+		{rest.SignTransformPath("other-secret", path), http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "/v1/img/"+test.signature+path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		testRecorder := httptest.NewRecorder()
+		router := chi.NewRouter()
+		router.Get("/v1/img/{signature}/*", handler.TransformImage)
+		router.ServeHTTP(testRecorder, req)
+
+		if testRecorder.Code != test.status {
+			t.Errorf("unexpected status code for signature %q: %v", test.signature, testRecorder.Code)
+		}
+	}
+}
+
+func TestTransformImageFailures(t *testing.T) {
+	path := rest.BuildTransformPath("https://i.imgur.com/RzW6QSI.jpeg", model.Transformation{Width: 200})
+
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&fetch.StatusError{StatusCode: http.StatusNotFound}, http.StatusNotFound},
+		{&fetch.StatusError{StatusCode: http.StatusInternalServerError}, http.StatusBadGateway},
+		{fmt.Errorf("%w: connection refused", image.ErrFetchFailed), http.StatusBadGateway},
+		{fmt.Errorf("%w: 20000x1 pixels", image.ErrImageTooLarge), http.StatusRequestEntityTooLarge},
+		{fmt.Errorf("%w: truncated", image.ErrDecodeFailed), http.StatusUnprocessableEntity},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{errors.New("encoder failed"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		settings, _ := settings.Load()
+		settings.Auth.URLSigningKey = "secret"
+		cache, _ := cache.NewLRUImageCache(1)
+		resizer := NewMockResizer(settings, cache).(*mockImageResizer)
+		resizer.transformErr = test.err
+		handler := rest.NewResizerHandler(settings, cache, resizer)
+
+		req, err := http.NewRequest("GET", "/v1/img/"+rest.SignTransformPath("secret", path)+path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		testRecorder := httptest.NewRecorder()
+		router := chi.NewRouter()
+		router.Get("/v1/img/{signature}/*", handler.TransformImage)
+		router.ServeHTTP(testRecorder, req)
+
+		if testRecorder.Code != test.status {
+			t.Errorf("unexpected status code for %v: %v", test.err, testRecorder.Code)
+		}
+	}
+}
+
+func TestTransformImageWithInvalidOptions(t *testing.T) {
+	handler := buildResizerHandler()
+	path := "/w:abc/" + strings.TrimPrefix(rest.BuildTransformPath("https://i.imgur.com/RzW6QSI.jpeg", model.Transformation{}), "/")
+
+	req, err := http.NewRequest("GET", "/v1/img/"+rest.SignTransformPath("secret", path)+path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	testRecorder := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/v1/img/{signature}/*", handler.TransformImage)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestResizeImageSyncCallback(t *testing.T) {
+	body := `{"urls": ["https://i.imgur.com/RzW6QSI.jpeg"], "width": 200, "callback_url": "https://example.com/hook"}`
+	req, _ := http.NewRequest("POST", "/v1/resize?async=false", strings.NewReader(body))
+
+	testRecorder := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Post("/v1/resize", buildResizerHandler().ResizeImage)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	handler := buildResizerHandler()
+	router := chi.NewRouter()
+	router.Post("/v1/resize", handler.ResizeImage)
+	router.Get("/v1/jobs/{batchID}", handler.GetJob)
+
+	req, _ := http.NewRequest("POST", "/v1/resize?async=true", strings.NewReader(json))
+	testRecorder := httptest.NewRecorder()
+	router.ServeHTTP(testRecorder, req)
+
+	location := testRecorder.Header().Get("Location")
+	if location != "/v1/jobs/batch789" {
+		t.Fatalf("unexpected location: %s", location)
+	}
+
+	req, _ = http.NewRequest("GET", location, nil)
+	testRecorder = httptest.NewRecorder()
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", testRecorder.Code)
+	}
+
+	body := testRecorder.Body.String()
+	if !strings.Contains(body, `"batch_id":"batch789"`) || !strings.Contains(body, `"state":"queued"`) {
+		t.Errorf("unexpected job: %s", body)
+	}
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/v1/jobs/missing", nil)
+
+	testRecorder := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/v1/jobs/{batchID}", buildResizerHandler().GetJob)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	handler := buildResizerHandler()
+	router := chi.NewRouter()
+	router.Post("/v1/resize", handler.ResizeImage)
+	router.Delete("/v1/jobs/{batchID}", handler.CancelJob)
+
+	req, _ := http.NewRequest("POST", "/v1/resize?async=true", strings.NewReader(json))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// a job can only be cancelled until all of its images are done
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/v1/jobs/batch789", http.StatusOK},
+		{"/v1/jobs/batch789", http.StatusConflict},
+		{"/v1/jobs/missing", http.StatusNotFound},
+	} {
+		req, _ = http.NewRequest("DELETE", tc.path, nil)
+		testRecorder := httptest.NewRecorder()
+		router.ServeHTTP(testRecorder, req)
+
+		if testRecorder.Code != tc.code {
+			t.Errorf("unexpected status code of %s: %v", tc.path, testRecorder.Code)
+		}
+		if tc.code == http.StatusOK && !strings.Contains(testRecorder.Body.String(), `"state":"cancelled"`) {
+			t.Errorf("unexpected job: %s", testRecorder.Body.String())
+		}
+	}
 }
 
 func buildResizerHandler() *rest.ResizerHandler {
-	settings, _ := settings.Load()
 	cache, _ := cache.NewLRUImageCache(1)
+	return buildResizerHandlerWithCache(cache)
+}
+
+func buildResizerHandlerWithCache(cache cache.ImageCacheAdapter) *rest.ResizerHandler {
+	settings, _ := settings.Load()
+	settings.Auth.URLSigningKey = "secret"
 	resizer := NewMockResizer(settings, cache)
 
 	return rest.NewResizerHandler(settings, cache, resizer)
@@ -83,14 +485,21 @@ func buildResizerHandler() *rest.ResizerHandler {
 type mockImageResizer struct {
 	settings         *settings.Settings
 	cache            cache.ImageCacheAdapter
-	resizingProgress *image.ResizingProgress
+	resizingProgress image.ResizingProgressAdapter
+	jobs             jobs.JobStoreAdapter
+	deadLetters      image.DeadLetterStoreAdapter
+	workers          int
+	transformErr     error
 }
 
 func NewMockResizer(settings *settings.Settings, cache cache.ImageCacheAdapter) image.ImageResizer {
 	return &mockImageResizer{
 		settings:         settings,
 		cache:            cache,
-		resizingProgress: image.NewResizingProgress(settings),
+		resizingProgress: image.NewLocalResizingProgress(settings),
+		jobs:             jobs.NewMemoryJobStore(time.Minute),
+		deadLetters:      image.NewMemoryDeadLetterStore(),
+		workers:          4,
 	}
 }
 
@@ -102,18 +511,121 @@ func (mir *mockImageResizer) Shutdown() {
 
 }
 
+func (mir *mockImageResizer) Workers() int {
+	return mir.workers
+}
+
+func (mir *mockImageResizer) SetWorkers(n int) error {
+	if !mir.settings.Service.AsyncResize {
+		return image.ErrAsyncResizeDisabled
+	}
+	if n < 1 {
+		return errors.New("number of workers must be at least 1")
+	}
+	mir.workers = n
+	return nil
+}
+
 func (mir *mockImageResizer) Process(_ *model.ResizeRequest, _ context.Context) ([]model.ResizeResponse, error) {
 	resp := make([]model.ResizeResponse, 0, 1)
 	resp = append(resp, model.ResizeResponse{Result: "success", ID: "abc123", Cached: false})
 	return resp, nil
 }
 
-func (mir *mockImageResizer) ProcessAsync(_ *model.ResizeRequest) []model.ResizeResponse {
+func (mir *mockImageResizer) ProcessStream(request *model.ResizeRequest, _ context.Context, emit func(int, model.ResizeResponse)) error {
+	for i, url := range request.URLs {
+		if strings.Contains(url, "404") {
+			emit(i, model.ResizeResponse{Result: "failure", URL: url, Error: "non-200 status: 404", ErrorCode: model.ErrorCodeOriginStatus, OriginStatus: 404})
+		} else {
+			emit(i, model.ResizeResponse{Result: "success", URL: url, ID: "abc123"})
+		}
+	}
+	return nil
+}
+
+func (mir *mockImageResizer) ProcessAsync(request *model.ResizeRequest) []model.ResizeResponse {
+	images := []model.JobImage{{URL: "https://i.imgur.com/RzW6QSI.jpeg", ID: "def456"}}
+	_ = mir.jobs.Create(context.Background(), model.NewJob("batch789", images, time.Now()))
+
+	// urls of a saturated queue are rejected, the others enqueued
+	if strings.Contains(strings.Join(request.URLs, " "), "queue-") {
+		resp := make([]model.ResizeResponse, 0, len(request.URLs))
+		for _, url := range request.URLs {
+			switch {
+			case strings.Contains(url, "queue-full"):
+				resp = append(resp, model.ResizeResponse{Result: "failure", URL: url, ErrorCode: model.ErrorCodeQueueFull, BatchID: "batch789", RetryAfter: 7})
+			case strings.Contains(url, "queue-down"):
+				resp = append(resp, model.ResizeResponse{Result: "failure", URL: url, ErrorCode: model.ErrorCodeQueueUnavailable, BatchID: "batch789", RetryAfter: 30})
+			default:
+				resp = append(resp, model.ResizeResponse{Result: "enqueued", URL: url, ID: "def456", BatchID: "batch789"})
+			}
+		}
+		return resp
+	}
+
 	resp := make([]model.ResizeResponse, 0, 1)
-	resp = append(resp, model.ResizeResponse{Result: "enqueued", ID: "def456", Cached: false})
+	resp = append(resp, model.ResizeResponse{Result: "enqueued", ID: "def456", Cached: false, BatchID: "batch789"})
 	return resp
 }
 
-func (mir *mockImageResizer) ResizingProgress() *image.ResizingProgress {
+func (mir *mockImageResizer) Transform(_ context.Context, _ string, _ model.Transformation) ([]byte, error) {
+	if mir.transformErr != nil {
+		return nil, mir.transformErr
+	}
+	return []byte("\x89PNG\r\n\x1a\nrest-of-png"), nil
+}
+
+// Images resized by the mock are cached as abc123.
+func (mir *mockImageResizer) PurgeSource(ctx context.Context, _ string) (int, error) {
+	deleted, err := mir.cache.Delete(ctx, "abc123")
+	if err != nil {
+		return 0, err
+	}
+	if deleted {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (mir *mockImageResizer) ResizingProgress() image.ResizingProgressAdapter {
 	return mir.resizingProgress
 }
+
+func (mir *mockImageResizer) Jobs() jobs.JobStoreAdapter {
+	return mir.jobs
+}
+
+func (mir *mockImageResizer) CancelJob(ctx context.Context, batchID string) (*model.Job, error) {
+	job, ok := mir.jobs.Get(ctx, batchID)
+	if !ok {
+		return nil, jobs.ErrJobNotFound
+	}
+	if job.FinishedAt != nil {
+		return nil, image.ErrJobFinished
+	}
+
+	err := mir.jobs.Update(ctx, batchID, func(job *model.Job) {
+		now := time.Now()
+		for i := range job.Images {
+			job.Images[i].Cancel(now)
+		}
+		job.UpdateState(now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job, _ = mir.jobs.Get(ctx, batchID)
+	return job, nil
+}
+
+func (mir *mockImageResizer) DeadLetters() image.DeadLetterStoreAdapter {
+	return mir.deadLetters
+}
+
+func (mir *mockImageResizer) RequeueDeadLetter(ctx context.Context, id string) error {
+	if _, ok := mir.deadLetters.Get(ctx, id); !ok {
+		return image.ErrDeadLetterNotFound
+	}
+	return mir.deadLetters.Delete(ctx, id)
+}
@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/scheduler"
+	"github.com/okulik/img-resize/internal/settings"
+	"github.com/okulik/img-resize/internal/web"
+)
+
+const schedulesPath = "/v1/schedules/"
+
+type ScheduleHandler struct {
+	settings  *settings.Settings
+	scheduler *scheduler.Scheduler
+}
+
+// Creates a new instance of ScheduleHandler object.
+func NewScheduleHandler(settings *settings.Settings, scheduler *scheduler.Scheduler) *ScheduleHandler {
+	return &ScheduleHandler{
+		settings:  settings,
+		scheduler: scheduler,
+	}
+}
+
+// A web handler for registering a source image URL to be refetched on a
+// cron-like schedule, and resized into the given variants whenever it
+// changed. The first run is due right away.
+func (sh *ScheduleHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	buffer, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to read request body"), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := model.NewScheduleFromJSON(buffer)
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid schedule body"), http.StatusBadRequest)
+		return
+	}
+
+	if len(schedule.Variants) > maxVariantCount {
+		web.WriteErrorResponse(w, errors.Errorf("number of variants is limited to %d", maxVariantCount), http.StatusBadRequest)
+		return
+	}
+
+	if err := image.ValidateSchedule(sh.settings, schedule); err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid schedule"), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := scheduler.ParseCron(schedule.Cron); err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "invalid cron"), http.StatusBadRequest)
+		return
+	}
+
+	if err := sh.scheduler.Create(r.Context(), schedule); err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to create schedule"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", schedulesPath+schedule.ID)
+	web.WriteJSONResponse(w, schedule, http.StatusCreated)
+}
+
+// A web handler for listing the schedules, oldest first.
+func (sh *ScheduleHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := sh.scheduler.Store().List(r.Context())
+	if err != nil {
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to list schedules"), http.StatusInternalServerError)
+		return
+	}
+
+	web.WriteJSONResponse(w, schedules, http.StatusOK)
+}
+
+// A web handler for retrieving a single schedule by its ID, along with the
+// outcome of its last run.
+func (sh *ScheduleHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	schedule, ok := sh.scheduler.Store().Get(r.Context(), id)
+	if !ok {
+		web.WriteErrorResponse(w, scheduler.ErrScheduleNotFound, http.StatusNotFound)
+		return
+	}
+
+	web.WriteJSONResponse(w, schedule, http.StatusOK)
+}
+
+// A web handler for removing a schedule. The variants it cached stay in the
+// cache until they're evicted.
+func (sh *ScheduleHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	err := sh.scheduler.Store().Delete(r.Context(), id)
+	switch {
+	case errors.Is(err, scheduler.ErrScheduleNotFound):
+		web.WriteErrorResponse(w, err, http.StatusNotFound)
+		return
+	case err != nil:
+		web.WriteErrorResponse(w, errors.Wrap(err, "failed to delete schedule"), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package rest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	chi "github.com/go-chi/chi/v5"
+
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/rest"
+	"github.com/okulik/img-resize/internal/scheduler"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+type noopRefresher struct{}
+
+func (noopRefresher) Refresh(_ context.Context, _ string, _ []model.Transformation, version model.SourceVersion) (model.SourceVersion, bool, error) {
+	return version, false, nil
+}
+
+func TestCreateSchedule(t *testing.T) {
+	router := buildScheduleRouter()
+
+	testRecorder := httptest.NewRecorder()
+	body := `{"url": "https://example.com/a.jpg", "cron": "@every 1h", "variants": [{"width": 100, "height": 100}]}`
+	req, _ := http.NewRequest("POST", "/v1/schedules", strings.NewReader(body))
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusCreated {
+		t.Fatalf("unexpected response: %v %s", testRecorder.Code, testRecorder.Body.String())
+	}
+
+	schedule, err := model.NewScheduleFromJSON(testRecorder.Body.Bytes())
+	if err != nil || schedule.ID == "" {
+		t.Fatalf("unexpected schedule: %s", testRecorder.Body.String())
+	}
+	if location := testRecorder.Header().Get("Location"); location != "/v1/schedules/"+schedule.ID {
+		t.Errorf("unexpected location: %s", location)
+	}
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/schedules/"+schedule.ID, nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK || !strings.Contains(testRecorder.Body.String(), `"cron":"@every 1h"`) {
+		t.Errorf("unexpected response: %v %s", testRecorder.Code, testRecorder.Body.String())
+	}
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/schedules", nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusOK || !strings.HasPrefix(testRecorder.Body.String(), `[{"id":"`+schedule.ID+`"`) {
+		t.Errorf("unexpected response: %v %s", testRecorder.Code, testRecorder.Body.String())
+	}
+}
+
+func TestCreateInvalidSchedule(t *testing.T) {
+	router := buildScheduleRouter()
+
+	bodies := []string{
+		`{"url": "https://example.com/a.jpg", "cron": "@every 1h", "variants": []}`,
+		`{"url": "ftp://example.com/a.jpg", "cron": "@every 1h", "variants": [{"width": 100, "height": 100}]}`,
+		`{"url": "https://example.com/a.jpg", "cron": "@every 1s", "variants": [{"width": 100, "height": 100}]}`,
+		`{"url": "https://example.com/a.jpg", "cron": "61 * * * *", "variants": [{"width": 100, "height": 100}]}`,
+		`{"url": `,
+	}
+
+	for _, body := range bodies {
+		testRecorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/schedules", strings.NewReader(body))
+		router.ServeHTTP(testRecorder, req)
+
+		if testRecorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: %v", body, testRecorder.Code)
+		}
+	}
+}
+
+func TestDeleteSchedule(t *testing.T) {
+	router := buildScheduleRouter()
+
+	testRecorder := httptest.NewRecorder()
+	body := `{"url": "https://example.com/a.jpg", "cron": "@daily", "variants": [{"width": 100, "height": 100}]}`
+	req, _ := http.NewRequest("POST", "/v1/schedules", strings.NewReader(body))
+	router.ServeHTTP(testRecorder, req)
+
+	schedule, _ := model.NewScheduleFromJSON(testRecorder.Body.Bytes())
+
+	testRecorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/v1/schedules/"+schedule.ID, nil)
+	router.ServeHTTP(testRecorder, req)
+
+	if testRecorder.Code != http.StatusNoContent {
+		t.Errorf("unexpected status code: %v", testRecorder.Code)
+	}
+
+	for _, method := range []string{"GET", "DELETE"} {
+		testRecorder = httptest.NewRecorder()
+		req, _ = http.NewRequest(method, "/v1/schedules/"+schedule.ID, nil)
+		router.ServeHTTP(testRecorder, req)
+
+		if testRecorder.Code != http.StatusNotFound {
+			t.Errorf("%s: unexpected status code: %v", method, testRecorder.Code)
+		}
+	}
+}
+
+func buildScheduleRouter() chi.Router {
+	settings, _ := settings.Load()
+	s := scheduler.NewScheduler(settings, scheduler.NewMemoryScheduleStore(), noopRefresher{})
+
+	handler := rest.NewScheduleHandler(settings, s)
+	router := chi.NewRouter()
+	router.Post("/v1/schedules", handler.CreateSchedule)
+	router.Get("/v1/schedules", handler.ListSchedules)
+	router.Get("/v1/schedules/{id}", handler.GetSchedule)
+	router.Delete("/v1/schedules/{id}", handler.DeleteSchedule)
+
+	return router
+}
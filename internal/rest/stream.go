@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeSSE    = "text/event-stream"
+)
+
+// streamWriter writes records to a streamed response, either as newline
+// delimited JSON or as server-sent events, flushing each record as soon as
+// it's written.
+type streamWriter struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	contentType  string
+	writeTimeout time.Duration
+}
+
+// Returns the streaming content type the client accepts, or an empty string
+// if it doesn't accept any.
+func streamContentType(r *http.Request) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if mediaType == contentTypeNDJSON || mediaType == contentTypeSSE {
+			return mediaType
+		}
+	}
+
+	return ""
+}
+
+// Creates a stream writer and writes the response headers.
+func newStreamWriter(w http.ResponseWriter, contentType string, writeTimeout time.Duration) *streamWriter {
+	sw := &streamWriter{
+		w:            w,
+		rc:           http.NewResponseController(w),
+		contentType:  contentType,
+		writeTimeout: writeTimeout,
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	sw.extendWriteDeadline()
+	w.WriteHeader(http.StatusOK)
+	sw.flush()
+
+	return sw
+}
+
+// Writes and flushes a single record. For server-sent events, the record
+// type is used as the event name.
+func (sw *streamWriter) Write(recordType string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	sw.extendWriteDeadline()
+	if sw.contentType == contentTypeSSE {
+		_, err = fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", recordType, data)
+	} else {
+		_, err = fmt.Fprintf(sw.w, "%s\n", data)
+	}
+	if err != nil {
+		return err
+	}
+
+	sw.flush()
+	return nil
+}
+
+// The server's write timeout covers the whole response, so it's pushed back
+// with the headers and every record, to keep long batches from getting cut
+// off.
+func (sw *streamWriter) extendWriteDeadline() {
+	if sw.writeTimeout > 0 {
+		_ = sw.rc.SetWriteDeadline(time.Now().Add(sw.writeTimeout))
+	}
+}
+
+func (sw *streamWriter) flush() {
+	_ = sw.rc.Flush()
+}
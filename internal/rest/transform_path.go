@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// A transformation path consists of the transformation options, followed by
+// the base64url encoded source URL, e.g. "/w:200/h:200/fit:cover/aHR0c...".
+// Each option is a name and a value, separated by a colon.
+
+// Returns the signature of a transformation path, which is the base64url
+// encoded HMAC-SHA256 of the path, keyed with the URL signing key.
+func SignTransformPath(key string, path string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(path))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Builds a transformation path for the source URL and the transformation
+// options, without the signature.
+func BuildTransformPath(url string, t model.Transformation) string {
+	options := []string{}
+	if t.Preset != "" {
+		options = append(options, "p:"+t.Preset)
+	}
+	if t.Width != 0 {
+		options = append(options, "w:"+strconv.FormatUint(uint64(t.Width), 10))
+	}
+	if t.Height != 0 {
+		options = append(options, "h:"+strconv.FormatUint(uint64(t.Height), 10))
+	}
+	if t.Fit != "" {
+		options = append(options, "fit:"+t.Fit)
+	}
+	if t.Gravity != "" {
+		options = append(options, "g:"+t.Gravity)
+	}
+	if t.Format != "" {
+		options = append(options, "f:"+t.Format)
+	}
+	if t.Quality != 0 {
+		options = append(options, "q:"+strconv.Itoa(t.Quality))
+	}
+	if t.Metadata != "" {
+		options = append(options, "m:"+t.Metadata)
+	}
+	options = append(options, base64.RawURLEncoding.EncodeToString([]byte(url)))
+
+	return "/" + strings.Join(options, "/")
+}
+
+func verifyTransformPath(key string, path string, signature string) bool {
+	expected := SignTransformPath(key, path)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// Parses a transformation path into the source URL and the transformation.
+func parseTransformPath(path string) (string, model.Transformation, error) {
+	t := model.Transformation{}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) == 0 || segments[len(segments)-1] == "" {
+		return "", t, errors.New("missing source url")
+	}
+
+	url, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segments[len(segments)-1], "="))
+	if err != nil {
+		return "", t, errors.Wrap(err, "invalid source url encoding")
+	}
+
+	for _, segment := range segments[:len(segments)-1] {
+		name, value, ok := strings.Cut(segment, ":")
+		if !ok {
+			return "", t, errors.Errorf("invalid option %s", segment)
+		}
+
+		switch name {
+		case "p", "preset":
+			t.Preset = value
+		case "w", "width":
+			width, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return "", t, errors.Errorf("invalid width %s", value)
+			}
+			t.Width = uint(width)
+		case "h", "height":
+			height, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return "", t, errors.Errorf("invalid height %s", value)
+			}
+			t.Height = uint(height)
+		case "fit":
+			t.Fit = value
+		case "g", "gravity":
+			t.Gravity = value
+		case "f", "format":
+			t.Format = value
+		case "q", "quality":
+			quality, err := strconv.Atoi(value)
+			if err != nil {
+				return "", t, errors.Errorf("invalid quality %s", value)
+			}
+			t.Quality = quality
+		case "m", "metadata":
+			t.Metadata = value
+		default:
+			return "", t, errors.Errorf("unknown option %s", name)
+		}
+	}
+
+	return string(url), t, nil
+}
@@ -0,0 +1,194 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The shortest interval a schedule may be run at, so that origins aren't
+// polled too hard. Only @every intervals need to be checked against it,
+// since cron expressions run once a minute at most.
+const minInterval = time.Minute
+
+// How far ahead the next run of a cron schedule is looked for, before it's
+// taken for one that never runs, like on February 30th.
+const maxCronLookahead = 5 * 366 * 24 * time.Hour
+
+// Returned when a cron schedule never runs.
+var errNeverRuns = errors.New("schedule never runs")
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// Cron tells when a schedule runs next.
+type Cron interface {
+	// Returns the first time the schedule runs after the given time.
+	Next(after time.Time) time.Time
+}
+
+// Parses a cron-like schedule. Either "@every" followed by an interval, like
+// "@every 30m", one of the macros, like "@hourly" or "@daily", or the five
+// fields of a cron expression: minute, hour, day of month, month and day of
+// week. Fields hold "*", values, ranges and steps, like "*/15" or "1-5",
+// separated by commas. Cron expressions are evaluated in UTC.
+func ParseCron(spec string) (Cron, error) {
+	spec = strings.TrimSpace(spec)
+
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %v", err)
+		}
+		if every < minInterval {
+			return nil, fmt.Errorf("interval must be at least %v", minInterval)
+		}
+		return everyCron(every), nil
+	}
+
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("expected @every, a macro, or 5 cron fields")
+	}
+
+	var c fieldsCron
+	bounds := []struct {
+		field    *uint64
+		name     string
+		min, max int
+	}{
+		{&c.minutes, "minute", 0, 59},
+		{&c.hours, "hour", 0, 23},
+		{&c.days, "day of month", 1, 31},
+		{&c.months, "month", 1, 12},
+		{&c.weekdays, "day of week", 0, 7},
+	}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", b.name, err)
+		}
+		*b.field = bits
+	}
+
+	// Sunday is both 0 and 7
+	if c.weekdays&(1<<7) != 0 {
+		c.weekdays |= 1
+	}
+	c.anyDay = fields[2] == "*"
+	c.anyWeekday = fields[4] == "*"
+
+	if c.Next(time.Now()).IsZero() {
+		return nil, errNeverRuns
+	}
+
+	return c, nil
+}
+
+// Parses a cron field into a bit set of the values it matches.
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %s", s)
+			}
+			rng, step = r, n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			l, h, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(l); err != nil {
+				return 0, fmt.Errorf("invalid value %s", l)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(h); err != nil {
+					return 0, fmt.Errorf("invalid value %s", h)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%s out of range %d-%d", rng, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+// everyCron runs at a fixed interval.
+type everyCron time.Duration
+
+func (c everyCron) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(c))
+}
+
+// fieldsCron runs at the minutes matching all of its fields, each a bit set
+// of the values it matches. As with cron, when both the day of month and the
+// day of week are restricted, a day matching either of them matches.
+type fieldsCron struct {
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+// Returns the zero time if the schedule doesn't run within the lookahead.
+func (c fieldsCron) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(maxCronLookahead)
+
+	for t.Before(end) {
+		switch {
+		case c.months&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hours&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c fieldsCron) matchDay(t time.Time) bool {
+	day := c.days&(1<<t.Day()) != 0
+	weekday := c.weekdays&(1<<int(t.Weekday())) != 0
+
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
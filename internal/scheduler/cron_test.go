@@ -0,0 +1,78 @@
+package scheduler_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/scheduler"
+)
+
+func TestParseCron(t *testing.T) {
+	after := time.Date(2026, time.March, 14, 10, 7, 30, 0, time.UTC) // a Saturday
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"@every 30m", after.Add(30 * time.Minute)},
+		{"@hourly", time.Date(2026, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.March, 14, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2026, time.March, 16, 9, 0, 0, 0, time.UTC)},
+		{"30 6,18 * * *", time.Date(2026, time.March, 14, 18, 30, 0, 0, time.UTC)},
+		{"0 0 1 * 7", time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		cron, err := scheduler.ParseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if next := cron.Next(after); !next.Equal(tt.next) {
+			t.Errorf("%s: expected next run at %v, got %v", tt.spec, tt.next, next)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"@every soon",
+		"@fortnightly",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"0 0 30 2 *",
+	}
+
+	for _, spec := range specs {
+		if _, err := scheduler.ParseCron(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestParseCronMinInterval(t *testing.T) {
+	if _, err := scheduler.ParseCron("@every 30s"); err == nil || !strings.Contains(err.Error(), "at least 1m0s") {
+		t.Errorf("expected an interval under a minute to be rejected, got: %v", err)
+	}
+
+	// schedules that run every minute, the most cron expressions can, are
+	// accepted either way
+	for _, spec := range []string{"@every 1m", "* * * * *"} {
+		if _, err := scheduler.ParseCron(spec); err != nil {
+			t.Errorf("%s: unexpected error: %v", spec, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// MemoryScheduleStore keeps schedules in memory.
+type MemoryScheduleStore struct {
+	schedules map[string]*model.Schedule
+	mu        sync.Mutex
+}
+
+func NewMemoryScheduleStore() ScheduleStoreAdapter {
+	return &MemoryScheduleStore{schedules: make(map[string]*model.Schedule)}
+}
+
+func (store *MemoryScheduleStore) Create(_ context.Context, schedule *model.Schedule) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.schedules[schedule.ID] = schedule.Clone()
+
+	return nil
+}
+
+func (store *MemoryScheduleStore) Get(_ context.Context, id string) (*model.Schedule, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	schedule, ok := store.schedules[id]
+	if !ok {
+		return nil, false
+	}
+
+	return schedule.Clone(), true
+}
+
+func (store *MemoryScheduleStore) List(_ context.Context) ([]*model.Schedule, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	schedules := make([]*model.Schedule, 0, len(store.schedules))
+	for _, schedule := range store.schedules {
+		schedules = append(schedules, schedule.Clone())
+	}
+	sortSchedules(schedules)
+
+	return schedules, nil
+}
+
+func (store *MemoryScheduleStore) Update(_ context.Context, id string, update func(schedule *model.Schedule) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	schedule, ok := store.schedules[id]
+	if !ok {
+		return ErrScheduleNotFound
+	}
+
+	updated := schedule.Clone()
+	if err := update(updated); err != nil {
+		return err
+	}
+	store.schedules[id] = updated
+
+	return nil
+}
+
+func (store *MemoryScheduleStore) Delete(_ context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	delete(store.schedules, id)
+
+	return nil
+}
+
+// Sorts the schedules oldest first.
+func sortSchedules(schedules []*model.Schedule) {
+	slices.SortFunc(schedules, func(a, b *model.Schedule) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
@@ -0,0 +1,67 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/scheduler"
+)
+
+func TestMemoryScheduleStore(t *testing.T) {
+	ctx := context.Background()
+	store := scheduler.NewMemoryScheduleStore()
+	now := time.Now()
+
+	for i, id := range []string{"b", "a"} {
+		schedule := &model.Schedule{ID: id, URL: "https://example.com/a.jpg", Cron: "@hourly", CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := store.Create(ctx, schedule); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	schedules, err := store.List(ctx)
+	if err != nil || len(schedules) != 2 || schedules[0].ID != "b" || schedules[1].ID != "a" {
+		t.Fatalf("unexpected schedules: %v %v", schedules, err)
+	}
+
+	// a failed update leaves the schedule as it was
+	failure := errors.New("failure")
+	err = store.Update(ctx, "a", func(schedule *model.Schedule) error {
+		schedule.Error = "changed"
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected update error, got: %v", err)
+	}
+	if schedule, _ := store.Get(ctx, "a"); schedule.Error != "" {
+		t.Errorf("expected schedule to be unchanged, got: %v", schedule)
+	}
+
+	err = store.Update(ctx, "a", func(schedule *model.Schedule) error {
+		schedule.Source.ETag = `"v2"`
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if schedule, ok := store.Get(ctx, "a"); !ok || schedule.Source.ETag != `"v2"` {
+		t.Errorf("unexpected schedule: %v", schedule)
+	}
+
+	if err := store.Update(ctx, "missing", func(_ *model.Schedule) error { return nil }); !errors.Is(err, scheduler.ErrScheduleNotFound) {
+		t.Errorf("expected schedule not found error, got: %v", err)
+	}
+
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := store.Get(ctx, "a"); ok {
+		t.Error("expected schedule to be deleted")
+	}
+	if err := store.Delete(ctx, "a"); !errors.Is(err, scheduler.ErrScheduleNotFound) {
+		t.Errorf("expected schedule not found error, got: %v", err)
+	}
+}
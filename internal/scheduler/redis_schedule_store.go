@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+const (
+	schedulesKey = "img-resize:schedules"
+
+	// How many times an update is retried when the schedules change under it.
+	maxUpdateRetries = 10
+)
+
+// RedisScheduleStore keeps schedules in a Redis hash, so that they're shared
+// by all instances.
+type RedisScheduleStore struct {
+	client *redis.Client
+}
+
+func NewRedisScheduleStore(client *redis.Client) ScheduleStoreAdapter {
+	return &RedisScheduleStore{client: client}
+}
+
+func (store *RedisScheduleStore) Create(ctx context.Context, schedule *model.Schedule) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+
+	return store.client.HSet(ctx, schedulesKey, schedule.ID, data).Err()
+}
+
+func (store *RedisScheduleStore) Get(ctx context.Context, id string) (*model.Schedule, bool) {
+	data, err := store.client.HGet(ctx, schedulesKey, id).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("error reading schedule %s: %v", id, err)
+		}
+		return nil, false
+	}
+
+	schedule := &model.Schedule{}
+	if err := json.Unmarshal(data, schedule); err != nil {
+		log.Printf("error decoding schedule %s: %v", id, err)
+		return nil, false
+	}
+
+	return schedule, true
+}
+
+func (store *RedisScheduleStore) List(ctx context.Context) ([]*model.Schedule, error) {
+	values, err := store.client.HGetAll(ctx, schedulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*model.Schedule, 0, len(values))
+	for id, data := range values {
+		schedule := &model.Schedule{}
+		if err := json.Unmarshal([]byte(data), schedule); err != nil {
+			log.Printf("error decoding schedule %s: %v", id, err)
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+	sortSchedules(schedules)
+
+	return schedules, nil
+}
+
+// The schedules are watched while one is being updated, and the update is
+// retried if they changed in the meantime, for instance by the scheduler of
+// another instance.
+func (store *RedisScheduleStore) Update(ctx context.Context, id string, update func(schedule *model.Schedule) error) error {
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.HGet(ctx, schedulesKey, id).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ErrScheduleNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		schedule := &model.Schedule{}
+		if err := json.Unmarshal(data, schedule); err != nil {
+			return err
+		}
+		if err := update(schedule); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(schedule); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, schedulesKey, id, data)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := store.client.Watch(ctx, txf, schedulesKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return redis.TxFailedErr
+}
+
+func (store *RedisScheduleStore) Delete(ctx context.Context, id string) error {
+	deleted, err := store.client.HDel(ctx, schedulesKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrScheduleNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package scheduler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/scheduler"
+)
+
+func TestRedisScheduleStoreList(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHGetAll("img-resize:schedules").SetVal(map[string]string{
+		"b":   `{"id":"b","url":"https://example.com/b.jpg","cron":"@daily","created_at":"2026-01-02T00:00:00Z"}`,
+		"a":   `{"id":"a","url":"https://example.com/a.jpg","cron":"@hourly","created_at":"2026-01-01T00:00:00Z"}`,
+		"bad": `{`,
+	})
+
+	store := scheduler.NewRedisScheduleStore(db)
+
+	schedules, err := store.List(context.Background())
+	if err != nil || len(schedules) != 2 || schedules[0].ID != "a" || schedules[1].ID != "b" {
+		t.Errorf("unexpected schedules: %v %v", schedules, err)
+	}
+}
+
+func TestRedisScheduleStoreUpdate(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	schedule := &model.Schedule{ID: "a", URL: "https://example.com/a.jpg", Cron: "@hourly", CreatedAt: time.Now().UTC()}
+	data, _ := json.Marshal(schedule)
+
+	schedule.Source.ETag = `"v2"`
+	updated, _ := json.Marshal(schedule)
+
+	mock.ExpectWatch("img-resize:schedules")
+	mock.ExpectHGet("img-resize:schedules", "a").SetVal(string(data))
+	mock.ExpectTxPipeline()
+	mock.ExpectHSet("img-resize:schedules", "a", updated).SetVal(0)
+	mock.ExpectTxPipelineExec()
+	mock.ExpectWatch("img-resize:schedules")
+	mock.ExpectHGet("img-resize:schedules", "missing").RedisNil()
+
+	store := scheduler.NewRedisScheduleStore(db)
+
+	err := store.Update(context.Background(), "a", func(schedule *model.Schedule) error {
+		schedule.Source.ETag = `"v2"`
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = store.Update(context.Background(), "missing", func(_ *model.Schedule) error { return nil })
+	if !errors.Is(err, scheduler.ErrScheduleNotFound) {
+		t.Errorf("expected schedule not found error, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisScheduleStoreDelete(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectHDel("img-resize:schedules", "a").SetVal(1)
+	mock.ExpectHDel("img-resize:schedules", "missing").SetVal(0)
+
+	store := scheduler.NewRedisScheduleStore(db)
+
+	if err := store.Delete(context.Background(), "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := store.Delete(context.Background(), "missing"); !errors.Is(err, scheduler.ErrScheduleNotFound) {
+		t.Errorf("expected schedule not found error, got: %v", err)
+	}
+}
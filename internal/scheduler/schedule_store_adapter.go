@@ -0,0 +1,22 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/okulik/img-resize/internal/model"
+)
+
+// Returned when a schedule doesn't exist.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+type ScheduleStoreAdapter interface {
+	Create(ctx context.Context, schedule *model.Schedule) error
+	Get(ctx context.Context, id string) (*model.Schedule, bool)
+	// Returns all schedules, oldest first.
+	List(ctx context.Context) ([]*model.Schedule, error)
+	// Atomically updates the schedule. If the update fails, the schedule is
+	// left as it was, and its error is returned.
+	Update(ctx context.Context, id string, update func(schedule *model.Schedule) error) error
+	Delete(ctx context.Context, id string) error
+}
@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+// How often schedules are checked for runs that are due, unless set.
+const defaultPollInterval = 15 * time.Second
+
+// Returned when claiming a run of a schedule that isn't due, because another
+// instance claimed it first.
+var errNotDue = errors.New("schedule run not due")
+
+// Refresher refetches source images, and resizes them anew when they
+// changed. It's implemented by image.Resizer.
+type Refresher interface {
+	Refresh(ctx context.Context, url string, variants []model.Transformation, version model.SourceVersion) (model.SourceVersion, bool, error)
+}
+
+// Scheduler runs the refreshes of the schedules in its store when they're
+// due. Each run is claimed in the store first, so that instances sharing the
+// store don't run it more than once.
+type Scheduler struct {
+	settings  *settings.Settings
+	store     ScheduleStoreAdapter
+	refresher Refresher
+	ctx       context.Context // cancelled once the scheduler is shut down
+	cancel    context.CancelFunc
+	running   map[string]bool // IDs of the schedules being run
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+}
+
+// Creates a new instance of the Scheduler object.
+func NewScheduler(settings *settings.Settings, store ScheduleStoreAdapter, refresher Refresher) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		settings:  settings,
+		store:     store,
+		refresher: refresher,
+		ctx:       ctx,
+		cancel:    cancel,
+		running:   make(map[string]bool),
+	}
+}
+
+// Starts checking for schedule runs that are due, every
+// SVC_SCHEDULER_POLL_INTERVAL.
+func (s *Scheduler) Start() {
+	interval := s.settings.Service.SchedulerPollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.RunDue(time.Now())
+
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stops checking for schedule runs, and aborts the runs in progress.
+func (s *Scheduler) Shutdown() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Returns the store of schedules.
+func (s *Scheduler) Store() ScheduleStoreAdapter {
+	return s.store
+}
+
+// Adds the schedule, to be first run as soon as it's checked for due runs,
+// so that its variants are cached right away.
+func (s *Scheduler) Create(ctx context.Context, schedule *model.Schedule) error {
+	if _, err := ParseCron(schedule.Cron); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	schedule.ID = newScheduleID()
+	schedule.CreatedAt = now
+	schedule.NextRunAt = now
+
+	return s.store.Create(ctx, schedule)
+}
+
+// Runs, in the background, the schedules whose runs are due at now, unless
+// they're running already.
+func (s *Scheduler) RunDue(now time.Time) {
+	schedules, err := s.store.List(s.ctx)
+	if err != nil {
+		log.Printf("failed to list schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if schedule.NextRunAt.After(now) || !s.startRun(schedule.ID) {
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.endRun(schedule.ID)
+			s.run(schedule.ID, now)
+		}()
+	}
+}
+
+// Claims the run of the schedule due at now, and refreshes its variants,
+// recording the outcome in the schedule.
+func (s *Scheduler) run(id string, now time.Time) {
+	var claimed *model.Schedule
+	err := s.store.Update(s.ctx, id, func(schedule *model.Schedule) error {
+		if schedule.NextRunAt.After(now) {
+			return errNotDue
+		}
+
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			return err
+		}
+		schedule.NextRunAt = cron.Next(now)
+		claimed = schedule.Clone()
+		return nil
+	})
+	if errors.Is(err, errNotDue) || errors.Is(err, ErrScheduleNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to claim run of schedule %s: %v", id, err)
+		return
+	}
+
+	version, changed, refreshErr := s.refresher.Refresh(s.ctx, claimed.URL, claimed.Variants, claimed.Source)
+	if refreshErr != nil {
+		log.Printf("failed to refresh %s of schedule %s: %v", claimed.URL, id, refreshErr)
+	} else if changed {
+		log.Printf("refreshed %s of schedule %s", claimed.URL, id)
+	}
+
+	err = s.store.Update(context.WithoutCancel(s.ctx), id, func(schedule *model.Schedule) error {
+		schedule.LastRunAt = &now
+		schedule.Error = ""
+		if refreshErr != nil {
+			schedule.Error = refreshErr.Error()
+			return nil
+		}
+		schedule.Source = version
+		if changed {
+			schedule.ChangedAt = &now
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrScheduleNotFound) {
+		log.Printf("failed to update schedule %s: %v", id, err)
+	}
+}
+
+// Marks the schedule as running on this instance. Returns false if it's
+// running already.
+func (s *Scheduler) startRun(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running[id] {
+		return false
+	}
+	s.running[id] = true
+
+	return true
+}
+
+func (s *Scheduler) endRun(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.running, id)
+}
+
+func newScheduleID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
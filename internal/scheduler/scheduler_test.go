@@ -0,0 +1,129 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/scheduler"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+type fakeRefresher struct {
+	mu       sync.Mutex
+	calls    []model.SourceVersion
+	version  model.SourceVersion
+	changed  bool
+	err      error
+	released chan struct{}
+}
+
+func (f *fakeRefresher) Refresh(_ context.Context, _ string, _ []model.Transformation, version model.SourceVersion) (model.SourceVersion, bool, error) {
+	if f.released != nil {
+		<-f.released
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, version)
+
+	return f.version, f.changed, f.err
+}
+
+func (f *fakeRefresher) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+func TestSchedulerRunDue(t *testing.T) {
+	settings := &settings.Settings{}
+	refresher := &fakeRefresher{version: model.SourceVersion{ETag: `"v1"`, Digest: "d1"}, changed: true}
+	s := scheduler.NewScheduler(settings, scheduler.NewMemoryScheduleStore(), refresher)
+	ctx := context.Background()
+
+	schedule := &model.Schedule{URL: "https://example.com/a.jpg", Cron: "@every 1h"}
+	if err := s.Create(ctx, schedule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+	s.RunDue(now)
+	s.Shutdown()
+
+	if refresher.Calls() != 1 {
+		t.Fatalf("expected 1 refresh, got %d", refresher.Calls())
+	}
+
+	got, _ := s.Store().Get(ctx, schedule.ID)
+	if got.Source.ETag != `"v1"` || got.LastRunAt == nil || got.ChangedAt == nil || got.Error != "" {
+		t.Errorf("unexpected schedule: %+v", got)
+	}
+	if !got.NextRunAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected next run at %v, got %v", now.Add(time.Hour), got.NextRunAt)
+	}
+
+	// not due again until the next run
+	s.RunDue(now.Add(time.Minute))
+	if refresher.Calls() != 1 {
+		t.Errorf("expected no refresh before the next run, got %d", refresher.Calls())
+	}
+}
+
+func TestSchedulerRunDueOnce(t *testing.T) {
+	settings := &settings.Settings{}
+	refresher := &fakeRefresher{released: make(chan struct{})}
+	s := scheduler.NewScheduler(settings, scheduler.NewMemoryScheduleStore(), refresher)
+	other := scheduler.NewScheduler(settings, s.Store(), refresher)
+
+	schedule := &model.Schedule{URL: "https://example.com/a.jpg", Cron: "@hourly"}
+	if err := s.Create(context.Background(), schedule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the run is claimed once, both while it's running and by other
+	// schedulers sharing the store
+	now := time.Now()
+	s.RunDue(now)
+	s.RunDue(now)
+	other.RunDue(now)
+	close(refresher.released)
+	s.Shutdown()
+	other.Shutdown()
+
+	if refresher.Calls() != 1 {
+		t.Errorf("expected 1 refresh, got %d", refresher.Calls())
+	}
+}
+
+func TestSchedulerRecordsRefreshError(t *testing.T) {
+	settings := &settings.Settings{}
+	refresher := &fakeRefresher{err: errors.New("origin unavailable")}
+	store := scheduler.NewMemoryScheduleStore()
+	s := scheduler.NewScheduler(settings, store, refresher)
+	ctx := context.Background()
+
+	schedule := &model.Schedule{URL: "https://example.com/a.jpg", Cron: "@hourly", Source: model.SourceVersion{Digest: "d1"}}
+	if err := s.Create(ctx, schedule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.RunDue(time.Now())
+	s.Shutdown()
+
+	got, _ := store.Get(ctx, schedule.ID)
+	if got.Error != "origin unavailable" || got.Source.Digest != "d1" || got.ChangedAt != nil {
+		t.Errorf("unexpected schedule: %+v", got)
+	}
+}
+
+func TestSchedulerCreateInvalidCron(t *testing.T) {
+	s := scheduler.NewScheduler(&settings.Settings{}, scheduler.NewMemoryScheduleStore(), &fakeRefresher{})
+
+	if err := s.Create(context.Background(), &model.Schedule{URL: "https://example.com/a.jpg", Cron: "@never"}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/okulik/img-resize/internal/cache"
 	"github.com/okulik/img-resize/internal/image"
 	"github.com/okulik/img-resize/internal/rest"
+	"github.com/okulik/img-resize/internal/scheduler"
 	"github.com/okulik/img-resize/internal/settings"
 )
 
@@ -18,24 +19,47 @@ const (
 	v1Path     string = "/v1"
 )
 
-func NewRouter(settings *settings.Settings, imageCache cache.ImageCacheAdapter, resizer image.ImageResizer) *chi.Mux {
+func NewRouter(settings *settings.Settings, imageCache cache.ImageCacheAdapter, resizer image.ImageResizer, scheduler *scheduler.Scheduler) *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(loggingMiddleware)
 	r.Get(healthPath, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok\n"))
 	})
-	r.Mount(v1Path, createV1Router(settings, imageCache, resizer))
+	r.Mount(v1Path, createV1Router(settings, imageCache, resizer, scheduler))
 
 	return r
 }
 
-func createV1Router(settings *settings.Settings, imageCache cache.ImageCacheAdapter, resizer image.ImageResizer) http.Handler {
+func createV1Router(settings *settings.Settings, imageCache cache.ImageCacheAdapter, resizer image.ImageResizer, scheduler *scheduler.Scheduler) http.Handler {
 	r := chi.NewRouter()
-	r.Use(middleware.BasicAuth(settings.Auth.Realm, map[string]string{settings.Auth.Username: settings.Auth.Password}))
 	resizerHandler := rest.NewResizerHandler(settings, imageCache, resizer)
-	r.Post("/resize", resizerHandler.ResizeImage)
-	r.Get("/image/{imageID}", resizerHandler.GetImage)
+	adminHandler := rest.NewAdminHandler(settings, resizer)
+	scheduleHandler := rest.NewScheduleHandler(settings, scheduler)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.BasicAuth(settings.Auth.Realm, map[string]string{settings.Auth.Username: settings.Auth.Password}))
+		r.Post("/resize", resizerHandler.ResizeImage)
+		r.Get("/image/{imageID}", resizerHandler.GetImage)
+		r.Delete("/image/{imageID}", resizerHandler.DeleteImage)
+		r.Post("/purge", resizerHandler.PurgeSource)
+		r.Get("/jobs/{batchID}", resizerHandler.GetJob)
+		r.Delete("/jobs/{batchID}", resizerHandler.CancelJob)
+		r.Get("/admin/dead-letters", adminHandler.ListDeadLetters)
+		r.Get("/admin/dead-letters/{id}", adminHandler.GetDeadLetter)
+		r.Post("/admin/dead-letters/{id}/requeue", adminHandler.RequeueDeadLetter)
+		r.Get("/admin/workers", adminHandler.GetWorkers)
+		r.Put("/admin/workers", adminHandler.SetWorkers)
+		r.Post("/schedules", scheduleHandler.CreateSchedule)
+		r.Get("/schedules", scheduleHandler.ListSchedules)
+		r.Get("/schedules/{id}", scheduleHandler.GetSchedule)
+		r.Delete("/schedules/{id}", scheduleHandler.DeleteSchedule)
+	})
+
+	// Signed transformation URLs carry their own authorization, so they're
+	// served without basic auth
+	if settings.Auth.URLSigningKey != "" {
+		r.Get("/img/{signature}/*", resizerHandler.TransformImage)
+	}
 
 	return r
 }
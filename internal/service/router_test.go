@@ -8,6 +8,7 @@ import (
 	chi "github.com/go-chi/chi/v5"
 	"github.com/okulik/img-resize/internal/cache"
 	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/scheduler"
 	"github.com/okulik/img-resize/internal/service"
 	"github.com/okulik/img-resize/internal/settings"
 )
@@ -72,10 +73,36 @@ func TestRouterWithHealthEndpoint(t *testing.T) {
 	}
 }
 
+func TestRouterWithTransformEndpoint(t *testing.T) {
+	settings, _ := settings.Load()
+	settings.Auth.URLSigningKey = "secret"
+	cache, _ := cache.NewLRUImageCache(1)
+	resizer := image.NewResizer(settings, cache)
+	router := service.NewRouter(settings, cache, resizer, scheduler.NewScheduler(settings, scheduler.NewMemoryScheduleStore(), resizer))
+
+	// transformation URLs aren't behind basic auth, but get rejected
+	// because of an invalid signature
+	req, _ := http.NewRequest("GET", "/v1/img/invalid/w:200/aHR0cHM6Ly9leGFtcGxlLmNvbS9hLmpwZw", nil)
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("invalid status returned: got %v want %v", status, http.StatusForbidden)
+	}
+
+	req, _ = http.NewRequest("GET", "/v1/image/3731df6b15afc23322056bf1e234b86b8cdf32f0999eec5ccd3fd6148c8065fd", nil)
+	rr = httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("invalid status returned: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
 func buildRouter() *chi.Mux {
 	settings, _ := settings.Load()
 	cache, _ := cache.NewLRUImageCache(1)
 	resizer := image.NewResizer(settings, cache)
 
-	return service.NewRouter(settings, cache, resizer)
+	return service.NewRouter(settings, cache, resizer, scheduler.NewScheduler(settings, scheduler.NewMemoryScheduleStore(), resizer))
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/okulik/img-resize/internal/cache"
 	"github.com/okulik/img-resize/internal/image"
+	"github.com/okulik/img-resize/internal/scheduler"
 	"github.com/okulik/img-resize/internal/settings"
 )
 
@@ -21,13 +22,15 @@ type Service struct {
 	settings   *settings.Settings
 	imageCache cache.ImageCacheAdapter
 	resizer    *image.Resizer
+	scheduler  *scheduler.Scheduler
 }
 
-func NewService(settings *settings.Settings, imageCache cache.ImageCacheAdapter, resizer *image.Resizer) *Service {
+func NewService(settings *settings.Settings, imageCache cache.ImageCacheAdapter, resizer *image.Resizer, scheduler *scheduler.Scheduler) *Service {
 	return &Service{
 		settings:   settings,
 		imageCache: imageCache,
 		resizer:    resizer,
+		scheduler:  scheduler,
 	}
 }
 
@@ -51,7 +54,7 @@ func (svc *Service) startHttpServer() (*http.Server, context.CancelFunc) {
 	baseCtx, baseCancel := context.WithCancel(context.Background())
 	server := http.Server{
 		Addr:         fmt.Sprintf(":%d", svc.settings.Http.ServerPort),
-		Handler:      NewRouter(svc.settings, svc.imageCache, svc.resizer),
+		Handler:      NewRouter(svc.settings, svc.imageCache, svc.resizer, svc.scheduler),
 		BaseContext:  func(_ net.Listener) context.Context { return baseCtx },
 		IdleTimeout:  svc.settings.Http.ServerIdleTimeout,
 		ReadTimeout:  svc.settings.Http.ServerReadTimeout,
@@ -87,6 +90,7 @@ func (svc *Service) handleSignals(server *http.Server) error {
 		context.WithTimeout(context.Background(), svc.settings.Http.ServerGracefulShutdownTimeout)
 	defer gracefulCancel()
 	defer svc.resizer.Shutdown()
+	defer svc.scheduler.Shutdown()
 
 	if err := server.Shutdown(gracefulCtx); err != nil {
 		return err
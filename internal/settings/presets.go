@@ -0,0 +1,33 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Preset is a named set of transformation options that resize requests can
+// refer to instead of spelling the options out.
+type Preset struct {
+	Width    uint   `json:"width"`
+	Height   uint   `json:"height"`
+	Format   string `json:"format"`
+	Quality  int    `json:"quality"`
+	Fit      string `json:"fit"`
+	Gravity  string `json:"gravity"`
+	Metadata string `json:"metadata"`
+}
+
+// Presets maps preset names to their definitions. It's read from a JSON
+// object, e.g. {"thumb": {"width": 150, "height": 150, "fit": "cover"}}.
+type Presets map[string]Preset
+
+// Implements envconfig.Decoder.
+func (p *Presets) Decode(value string) error {
+	presets := map[string]Preset{}
+	if err := json.Unmarshal([]byte(value), &presets); err != nil {
+		return fmt.Errorf("invalid presets: %v", err)
+	}
+
+	*p = presets
+	return nil
+}
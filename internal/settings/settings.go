@@ -9,13 +9,48 @@ import (
 )
 
 type ServiceSettings struct {
-	ImageCacheSize     int           `envconfig:"SVC_IMG_CACHE_SIZE" default:"1024"`
-	ImageCacheTTL      time.Duration `envconfig:"SVC_IMG_CACHE_SIZE" default:"1h"`
-	AsyncResize        bool          `envconfig:"SVC_ASYNC_RESIZE" default:"true"`
-	ImageResizeTimeout time.Duration `envconfig:"SVC_IMG_RESIZE_TIMEOUT" default:"5s"`
-	MaxImageSize       int64         `envconfig:"SVC_MAX_IMG_SIZE" default:"15728640"`
-	RedisHost          string        `envconfig:"SVC_REDIS_HOST" default:"0.0.0.0"`
-	RedisPort          int           `envconfig:"SVC_REDIS_PORT" default:"6379"`
+	ImageCacheSize         int           `envconfig:"SVC_IMG_CACHE_SIZE" default:"1024"`
+	ImageCacheTTL          time.Duration `envconfig:"SVC_IMG_CACHE_SIZE" default:"1h"`
+	AsyncResize            bool          `envconfig:"SVC_ASYNC_RESIZE" default:"true"`
+	ImageResizeTimeout     time.Duration `envconfig:"SVC_IMG_RESIZE_TIMEOUT" default:"5s"`
+	MaxImageSize           int64         `envconfig:"SVC_MAX_IMG_SIZE" default:"15728640"`
+	MaxImageWidth          int           `envconfig:"SVC_MAX_IMG_WIDTH" default:"12000"`
+	MaxImageHeight         int           `envconfig:"SVC_MAX_IMG_HEIGHT" default:"12000"`
+	MaxImageMegapixels     float64       `envconfig:"SVC_MAX_IMG_MEGAPIXELS" default:"50"`
+	MaxTargetWidth         uint          `envconfig:"SVC_MAX_TARGET_WIDTH" default:"4096"`
+	MaxTargetHeight        uint          `envconfig:"SVC_MAX_TARGET_HEIGHT" default:"4096"`
+	RedisHost              string        `envconfig:"SVC_REDIS_HOST" default:"0.0.0.0"`
+	RedisPort              int           `envconfig:"SVC_REDIS_PORT" default:"6379"`
+	Presets                Presets       `envconfig:"SVC_PRESETS"`
+	Concurrency            int           `envconfig:"SVC_CONCURRENCY" default:"16"`
+	CPUConcurrency         int           `envconfig:"SVC_CPU_CONCURRENCY" default:"0"`
+	ResizeWorkers          int           `envconfig:"SVC_RESIZE_WORKERS" default:"0"`
+	ResizeQueueSize        int           `envconfig:"SVC_RESIZE_QUEUE_SIZE" default:"0"`
+	JobRetention           time.Duration `envconfig:"SVC_JOB_RETENTION" default:"24h"`
+	WebhookSecret          string        `envconfig:"SVC_WEBHOOK_SECRET"`
+	WebhookRetryMax        int           `envconfig:"SVC_WEBHOOK_RETRY_MAX" default:"5"`
+	WebhookRetryBackoff    time.Duration `envconfig:"SVC_WEBHOOK_RETRY_BACKOFF" default:"1s"`
+	WebhookRetryMaxBackoff time.Duration `envconfig:"SVC_WEBHOOK_RETRY_MAX_BACKOFF" default:"1m"`
+	QueueBackend           string        `envconfig:"SVC_QUEUE_BACKEND" default:"memory"`
+	QueueVisibilityTimeout time.Duration `envconfig:"SVC_QUEUE_VISIBILITY_TIMEOUT" default:"1m"`
+	QueueHighSize          int           `envconfig:"SVC_QUEUE_HIGH_SIZE" default:"0"`
+	QueueHighWeight        int           `envconfig:"SVC_QUEUE_HIGH_WEIGHT" default:"6"`
+	QueueHighFullPolicy    string        `envconfig:"SVC_QUEUE_HIGH_FULL_POLICY" default:"wait"`
+	QueueNormalSize        int           `envconfig:"SVC_QUEUE_NORMAL_SIZE" default:"0"`
+	QueueNormalWeight      int           `envconfig:"SVC_QUEUE_NORMAL_WEIGHT" default:"3"`
+	QueueNormalFullPolicy  string        `envconfig:"SVC_QUEUE_NORMAL_FULL_POLICY" default:"reject"`
+	QueueBulkSize          int           `envconfig:"SVC_QUEUE_BULK_SIZE" default:"0"`
+	QueueBulkWeight        int           `envconfig:"SVC_QUEUE_BULK_WEIGHT" default:"1"`
+	QueueBulkFullPolicy    string        `envconfig:"SVC_QUEUE_BULK_FULL_POLICY" default:"reject"`
+	QueueFullWait          time.Duration `envconfig:"SVC_QUEUE_FULL_WAIT" default:"1s"`
+	ShutdownDrainTimeout   time.Duration `envconfig:"SVC_SHUTDOWN_DRAIN_TIMEOUT" default:"5s"`
+	JobSnapshotPath        string        `envconfig:"SVC_JOB_SNAPSHOT_PATH" default:"img-resize-jobs.json"`
+	SchedulerPollInterval  time.Duration `envconfig:"SVC_SCHEDULER_POLL_INTERVAL" default:"15s"`
+	ProgressBackend        string        `envconfig:"SVC_PROGRESS_BACKEND"`
+	ResizingLockTTL        time.Duration `envconfig:"SVC_RESIZING_LOCK_TTL" default:"5m"`
+	RetryMaxAttempts       int           `envconfig:"SVC_RETRY_MAX_ATTEMPTS" default:"3"`
+	RetryBackoff           time.Duration `envconfig:"SVC_RETRY_BACKOFF" default:"5s"`
+	RetryMaxBackoff        time.Duration `envconfig:"SVC_RETRY_MAX_BACKOFF" default:"5m"`
 }
 
 type HttpSettings struct {
@@ -25,13 +60,28 @@ type HttpSettings struct {
 	ServerReadTimeout             time.Duration `envconfig:"HTTP_SERVER_READ_TIMEOUT" default:"10s"`
 	ServerWriteTimeout            time.Duration `envconfig:"HTTP_SERVER_WRITE_TIMEOUT" default:"20s"`
 	ClientReadTimeout             time.Duration `envconfig:"HTTP_CLIENT_READ_TIMEOUT" default:"10s"`
+	ClientConnectTimeout          time.Duration `envconfig:"HTTP_CLIENT_CONNECT_TIMEOUT" default:"5s"`
+	ClientTLSHandshakeTimeout     time.Duration `envconfig:"HTTP_CLIENT_TLS_HANDSHAKE_TIMEOUT" default:"5s"`
+	ClientResponseHeaderTimeout   time.Duration `envconfig:"HTTP_CLIENT_RESPONSE_HEADER_TIMEOUT" default:"5s"`
+	ClientMaxIdleConns            int           `envconfig:"HTTP_CLIENT_MAX_IDLE_CONNS" default:"100"`
+	ClientMaxIdleConnsPerHost     int           `envconfig:"HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST" default:"10"`
+	ClientRetryMax                int           `envconfig:"HTTP_CLIENT_RETRY_MAX" default:"2"`
+	ClientRetryBackoff            time.Duration `envconfig:"HTTP_CLIENT_RETRY_BACKOFF" default:"200ms"`
+	ClientRetryMaxBackoff         time.Duration `envconfig:"HTTP_CLIENT_RETRY_MAX_BACKOFF" default:"2s"`
 	ClientUserAgent               string        `envconfig:"HTTP_CLIENT_USER_AGENT" default:"img-resize"`
+	ClientAllowedSchemes          []string      `envconfig:"HTTP_CLIENT_ALLOWED_SCHEMES" default:"http,https"`
+	ClientAllowedHosts            []string      `envconfig:"HTTP_CLIENT_ALLOWED_HOSTS"`
+	ClientDeniedHosts             []string      `envconfig:"HTTP_CLIENT_DENIED_HOSTS"`
+	ClientAllowPrivateNetworks    bool          `envconfig:"HTTP_CLIENT_ALLOW_PRIVATE_NETWORKS" default:"false"`
 }
 
 type AuthSettings struct {
 	Username string `envconfig:"AUTH_USERNAME" required:"true"`
 	Password string `envconfig:"AUTH_PASSWORD" required:"true"`
 	Realm    string `envconfig:"AUTH_REALM" default:"localhost"`
+	// Key that signed transformation URLs are verified with. Signed URLs
+	// are disabled when it's empty.
+	URLSigningKey string `envconfig:"AUTH_URL_SIGNING_KEY"`
 }
 
 type Settings struct {
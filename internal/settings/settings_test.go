@@ -50,6 +50,10 @@ func TestSettingsLoad(t *testing.T) {
 		t.Error("unexpected value for HTTP_CLIENT_READ_TIMEOUT")
 	}
 
+	if settings.Http.ClientRetryMax != 9 {
+		t.Error("unexpected value for HTTP_CLIENT_RETRY_MAX")
+	}
+
 	if settings.Auth.Username != "admin1" {
 		t.Error("unexpected value for AUTH_USERNAME")
 	}
@@ -62,3 +66,36 @@ func TestSettingsLoad(t *testing.T) {
 		t.Error("unexpected value for AUTH_REALM")
 	}
 }
+
+func TestSettingsLoadPresets(t *testing.T) {
+	os.Setenv("AUTH_USERNAME", "admin1")
+	os.Setenv("AUTH_PASSWORD", "admin2")
+	os.Setenv("SVC_PRESETS", `{"thumb": {"width": 150, "height": 150, "fit": "cover"}, "hero": {"width": 1920, "format": "png"}}`)
+	defer os.Unsetenv("SVC_PRESETS")
+
+	settings, err := settings.Load()
+	if err != nil {
+		t.Fatalf("unable to load settings: %v", err)
+	}
+
+	thumb, ok := settings.Service.Presets["thumb"]
+	if !ok || thumb.Width != 150 || thumb.Height != 150 || thumb.Fit != "cover" {
+		t.Errorf("unexpected value for thumb preset: %v", thumb)
+	}
+
+	hero, ok := settings.Service.Presets["hero"]
+	if !ok || hero.Width != 1920 || hero.Format != "png" {
+		t.Errorf("unexpected value for hero preset: %v", hero)
+	}
+}
+
+func TestSettingsLoadInvalidPresets(t *testing.T) {
+	os.Setenv("AUTH_USERNAME", "admin1")
+	os.Setenv("AUTH_PASSWORD", "admin2")
+	os.Setenv("SVC_PRESETS", `{"thumb": 150}`)
+	defer os.Unsetenv("SVC_PRESETS")
+
+	if _, err := settings.Load(); err == nil {
+		t.Error("expected invalid presets to fail loading settings")
+	}
+}
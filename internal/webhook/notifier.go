@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/okulik/img-resize/internal/fetch"
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+)
+
+const (
+	// Name of the header carrying the signature of a callback.
+	SignatureHeader = "X-Signature"
+	// Name of the header carrying the Unix time, in seconds, a callback was
+	// signed at.
+	TimestampHeader = "X-Signature-Timestamp"
+	// How far from the current time a callback's timestamp may be, for the
+	// callback to be accepted by Verify. Older callbacks are taken to be
+	// replayed.
+	SignatureTolerance = 5 * time.Minute
+)
+
+// Notifier posts JSON payloads to callback URLs, signed with the webhook
+// secret. Callback URLs are untrusted, so they're posted to with the same
+// guarded client that fetches source images.
+type Notifier struct {
+	settings   *settings.Settings
+	httpClient *http.Client
+}
+
+// Creates a new instance of the Notifier object.
+func NewNotifier(settings *settings.Settings) *Notifier {
+	return &Notifier{
+		settings:   settings,
+		httpClient: fetch.NewClient(settings),
+	}
+}
+
+// Returns the signature of a callback, which is the hex encoded HMAC-SHA256
+// of the timestamp, a dot and the body, keyed with the secret and prefixed
+// with the name of the hash, e.g. "sha256=4f2a...". Signing the timestamp
+// keeps a captured callback from being replayed later on.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Reports whether the signature and timestamp headers of a callback match
+// its body, and the timestamp is within SignatureTolerance of now.
+func Verify(secret string, header http.Header, body []byte, now time.Time) bool {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > SignatureTolerance || age < -SignatureTolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body)))
+}
+
+// Posts the payload to the URL until it's accepted with a 2xx status, or
+// until WebhookRetryMax retries have failed, backing off exponentially in
+// between. Each attempt is passed to record as soon as it's done.
+func (n *Notifier) Deliver(ctx context.Context, url string, payload any, record func(model.CallbackAttempt)) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		statusCode, err := n.post(ctx, url, body)
+
+		result := model.CallbackAttempt{At: time.Now(), StatusCode: statusCode}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		record(result)
+
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("failed to deliver callback to %s: %v", url, err)
+		if attempt >= n.settings.Service.WebhookRetryMax {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(fetch.Backoff(n.settings.Service.WebhookRetryBackoff, n.settings.Service.WebhookRetryMaxBackoff, attempt)):
+		}
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("User-Agent", n.settings.Http.ClientUserAgent)
+	timestamp := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(n.settings.Service.WebhookSecret, timestamp, body))
+
+	res, err := n.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("non-2xx status: %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
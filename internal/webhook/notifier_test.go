@@ -0,0 +1,113 @@
+package webhook_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/okulik/img-resize/internal/model"
+	"github.com/okulik/img-resize/internal/settings"
+	"github.com/okulik/img-resize/internal/webhook"
+)
+
+func TestNotifierDeliver(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if !webhook.Verify("secret", r.Header, body, time.Now()) {
+			t.Errorf("unexpected signature: %s", r.Header.Get(webhook.SignatureHeader))
+		}
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	attempts := []model.CallbackAttempt{}
+	err := webhook.NewNotifier(buildSettings()).Deliver(context.Background(), server.URL, map[string]string{"batch_id": "batch"}, func(attempt model.CallbackAttempt) {
+		attempts = append(attempts, attempt)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(attempts) != 3 || attempts[0].StatusCode != http.StatusServiceUnavailable || attempts[0].Error == "" {
+		t.Fatalf("unexpected attempts: %v", attempts)
+	}
+
+	if attempts[2].StatusCode != http.StatusNoContent || attempts[2].Error != "" {
+		t.Errorf("unexpected last attempt: %v", attempts[2])
+	}
+}
+
+func TestNotifierGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	attempts := 0
+	err := webhook.NewNotifier(buildSettings()).Deliver(context.Background(), server.URL, nil, func(_ model.CallbackAttempt) {
+		attempts++
+	})
+	if err == nil {
+		t.Fatal("expected delivery to fail")
+	}
+
+	if attempts != 4 {
+		t.Errorf("expected 4 attempts, got: %d", attempts)
+	}
+}
+
+func TestSign(t *testing.T) {
+	signature := webhook.Sign("secret", 1700000000, []byte(`{"batch_id":"batch"}`))
+	if signature != "sha256=57427aca25d89e3a6dd66acf01228184dacf18b09319a8bb4f1deb9dd7476307" {
+		t.Errorf("unexpected signature: %s", signature)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"batch_id":"batch"}`)
+	signedAt := time.Unix(1700000000, 0)
+
+	header := http.Header{}
+	header.Set(webhook.TimestampHeader, "1700000000")
+	header.Set(webhook.SignatureHeader, webhook.Sign("secret", signedAt.Unix(), body))
+
+	if !webhook.Verify("secret", header, body, signedAt.Add(time.Minute)) {
+		t.Error("expected a fresh callback to be verified")
+	}
+
+	if webhook.Verify("secret", header, body, signedAt.Add(webhook.SignatureTolerance+time.Second)) {
+		t.Error("expected a stale callback to be rejected")
+	}
+
+	if webhook.Verify("other-secret", header, body, signedAt) {
+		t.Error("expected a callback signed with another secret to be rejected")
+	}
+
+	header.Set(webhook.TimestampHeader, "1700000001")
+	if webhook.Verify("secret", header, body, signedAt) {
+		t.Error("expected a callback with a changed timestamp to be rejected")
+	}
+}
+
+func buildSettings() *settings.Settings {
+	return &settings.Settings{
+		Service: &settings.ServiceSettings{
+			WebhookSecret:   "secret",
+			WebhookRetryMax: 3,
+		},
+		Auth: &settings.AuthSettings{},
+		Http: &settings.HttpSettings{
+			// test callbacks are served from the loopback interface
+			ClientAllowPrivateNetworks: true,
+		},
+	}
+}